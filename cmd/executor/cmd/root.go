@@ -18,6 +18,8 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -27,8 +29,10 @@ import (
 	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/creds"
 	"github.com/GoogleContainerTools/kaniko/pkg/executor"
 	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+	"github.com/GoogleContainerTools/kaniko/pkg/transport"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/genuinetools/amicontained/container"
 	"github.com/pkg/errors"
@@ -41,6 +45,9 @@ var (
 	opts     = &config.KanikoOptions{}
 	logLevel string
 	force    bool
+
+	// for testing
+	dockerfilePath = constants.DockerfilePath
 )
 
 func init() {
@@ -67,12 +74,54 @@ var RootCmd = &cobra.Command{
 			if err := resolveSourceContext(); err != nil {
 				return errors.Wrap(err, "error resolving source context")
 			}
+			if opts.DockerfileContent != "" && cmd.Flags().Changed("dockerfile") {
+				return errors.New("You must provide only one of --dockerfile or --dockerfile-content")
+			}
 			if err := resolveDockerfilePath(); err != nil {
 				return errors.Wrap(err, "error resolving dockerfile path")
 			}
+			addIgnorePaths()
 			if len(opts.Destinations) == 0 && opts.ImageNameDigestFile != "" {
 				return errors.New("You must provide --destination if setting ImageNameDigestFile")
 			}
+			if err := creds.SetRegistryCredentials(opts.RegistryCredentials, opts.RegistryAuths); err != nil {
+				return errors.Wrap(err, "error setting up registry credentials")
+			}
+			for _, registries := range []map[string][]string{opts.RegistryCertificates, opts.RegistryClientCerts} {
+				for registry := range registries {
+					if _, err := transport.TLSConfig(opts, registry, false); err != nil {
+						return errors.Wrap(err, "invalid registry TLS configuration")
+					}
+				}
+			}
+			if opts.CustomPlatform != "" {
+				if _, err := config.ParsePlatform(opts.CustomPlatform); err != nil {
+					return err
+				}
+			}
+			switch opts.BaseImageLockMode {
+			case constants.BaseImageLockModeStrict, constants.BaseImageLockModeWarn, constants.BaseImageLockModeUpdate:
+			default:
+				return fmt.Errorf("invalid --base-image-lock-mode %s", opts.BaseImageLockMode)
+			}
+			if opts.SourceDateEpoch != "" {
+				if _, err := util.ParseSourceDateEpoch(opts.SourceDateEpoch); err != nil {
+					return errors.Wrap(err, "invalid --source-date-epoch")
+				}
+			}
+			if opts.VerifyReproducibleImage != "" && !opts.VerifyReproducible {
+				return errors.New("You must provide --verify-reproducible if setting --verify-reproducible-image")
+			}
+			switch opts.MaxSizeMode {
+			case constants.MaxSizeModeFail, constants.MaxSizeModeWarn:
+			default:
+				return fmt.Errorf("invalid --max-size-mode %s", opts.MaxSizeMode)
+			}
+			if opts.ImageRewriteRules != "" {
+				if _, err := util.LoadImageRewriteRules(opts.ImageRewriteRules); err != nil {
+					return errors.Wrap(err, "invalid image rewrite rules")
+				}
+			}
 		}
 		return nil
 	},
@@ -96,6 +145,12 @@ var RootCmd = &cobra.Command{
 		if err != nil {
 			exit(errors.Wrap(err, "error building image"))
 		}
+		if opts.VerifyReproducible {
+			image, err = executor.VerifyReproducible(image, opts)
+			if err != nil {
+				exit(errors.Wrap(err, "error verifying the build is reproducible"))
+			}
+		}
 		if err := executor.DoPush(image, opts); err != nil {
 			exit(errors.Wrap(err, "error pushing image"))
 		}
@@ -119,11 +174,13 @@ var RootCmd = &cobra.Command{
 
 // addKanikoOptionsFlags configures opts
 func addKanikoOptionsFlags() {
-	RootCmd.PersistentFlags().StringVarP(&opts.DockerfilePath, "dockerfile", "f", "Dockerfile", "Path to the dockerfile to be built.")
+	RootCmd.PersistentFlags().StringVarP(&opts.DockerfilePath, "dockerfile", "f", "Dockerfile", "Path to the dockerfile to be built. Set it to - to read the dockerfile from stdin.")
+	RootCmd.PersistentFlags().StringVarP(&opts.DockerfileContent, "dockerfile-content", "", "", "Contents of the dockerfile to be built, instead of reading it from --dockerfile.")
 	RootCmd.PersistentFlags().StringVarP(&opts.SrcContext, "context", "c", "/workspace/", "Path to the dockerfile build context.")
 	RootCmd.PersistentFlags().StringVarP(&opts.Bucket, "bucket", "b", "", "Name of the GCS bucket from which to access build context as tarball.")
 	RootCmd.PersistentFlags().VarP(&opts.Destinations, "destination", "d", "Registry the final image should be pushed to. Set it repeatedly for multiple destinations.")
 	RootCmd.PersistentFlags().StringVarP(&opts.SnapshotMode, "snapshotMode", "", "full", "Change the file attributes inspected during snapshotting")
+	RootCmd.PersistentFlags().IntVarP(&opts.SnapshotWorkers, "snapshot-workers", "", 0, "Number of files hashed concurrently when snapshotting the filesystem. Defaults to the number of CPUs.")
 	RootCmd.PersistentFlags().VarP(&opts.BuildArgs, "build-arg", "", "This flag allows you to pass in ARG values at build time. Set it repeatedly for multiple values.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Insecure, "insecure", "", false, "Push to insecure registry using plain HTTP")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SkipTLSVerify, "skip-tls-verify", "", false, "Push to insecure registry ignoring TLS verify")
@@ -132,6 +189,9 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().StringVarP(&opts.TarPath, "tarPath", "", "", "Path to save the image in as a tarball instead of pushing")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SingleSnapshot, "single-snapshot", "", false, "Take a single snapshot at the end of the build.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Reproducible, "reproducible", "", false, "Strip timestamps out of the image to make it reproducible")
+	RootCmd.PersistentFlags().StringVarP(&opts.SourceDateEpoch, "source-date-epoch", "", os.Getenv(constants.SourceDateEpoch), "Seconds since the Unix epoch to clamp the modification times in the layers built by kaniko to, and to set as the creation time of the image. Defaults to $SOURCE_DATE_EPOCH.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.VerifyReproducible, "verify-reproducible", "", false, "Build the image a second time from an empty root, and fail with the differing layers and files if it is not the same.")
+	RootCmd.PersistentFlags().StringVarP(&opts.VerifyReproducibleImage, "verify-reproducible-image", "", "", "With --verify-reproducible, compare the image to this previously pushed image instead of building it a second time.")
 	RootCmd.PersistentFlags().StringVarP(&opts.Target, "target", "", "", "Set the target build stage to build")
 	RootCmd.PersistentFlags().BoolVarP(&opts.NoPush, "no-push", "", false, "Do not push the image to the registry")
 	RootCmd.PersistentFlags().StringVarP(&opts.CacheRepo, "cache-repo", "", "", "Specify a repository to use as a cache, otherwise one will be inferred from the destination provided")
@@ -139,11 +199,30 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().StringVarP(&opts.DigestFile, "digest-file", "", "", "Specify a file to save the digest of the built image to.")
 	RootCmd.PersistentFlags().StringVarP(&opts.ImageNameDigestFile, "image-name-with-digest-file", "", "", "Specify a file to save the image name w/ digest of the built image to.")
 	RootCmd.PersistentFlags().StringVarP(&opts.OCILayoutPath, "oci-layout-path", "", "", "Path to save the OCI image layout of the built image.")
+	RootCmd.PersistentFlags().StringVarP(&opts.LayerReport, "layer-report", "", "", "Path to write a JSON report of the paths added, modified and deleted by each layer to.")
+	RootCmd.PersistentFlags().VarP(&opts.MaxLayerSize, "max-layer-size", "", "Maximum size of the uncompressed layers added to the final image, e.g. 500MB. Unlimited by default.")
+	RootCmd.PersistentFlags().VarP(&opts.MaxImageSize, "max-image-size", "", "Maximum size of the compressed layers of the final image, including those of the base image, e.g. 2GB. Unlimited by default.")
+	RootCmd.PersistentFlags().StringVarP(&opts.MaxSizeMode, "max-size-mode", "", constants.MaxSizeModeFail, "What to do when --max-layer-size or --max-image-size is exceeded: fail or warn.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Cache, "cache", "", false, "Use cache when building image")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Cleanup, "cleanup", "", false, "Clean the filesystem at the end")
+	RootCmd.PersistentFlags().BoolVarP(&opts.PushIgnoreFailures, "push-ignore-failures", "", false, "Don't fail the build if pushing to some of the destinations fails, as long as one push succeeds.")
+	RootCmd.PersistentFlags().IntVarP(&opts.PushRetry, "push-retry", "", 0, "Number of retries for pushing the image after a transient error, with exponential backoff. Defaults to 0.")
+	RootCmd.PersistentFlags().IntVarP(&opts.PullRetry, "pull-retry", "", 0, "Number of retries for pulling images after a transient error, with exponential backoff. Defaults to 0.")
+	RootCmd.PersistentFlags().IntVarP(&opts.CacheRetry, "cache-retry", "", 0, "Number of retries for looking up and pushing cached layers after a transient error, with exponential backoff. Defaults to 0.")
 	RootCmd.PersistentFlags().DurationVarP(&opts.CacheTTL, "cache-ttl", "", time.Hour*336, "Cache timeout in hours. Defaults to two weeks.")
 	RootCmd.PersistentFlags().VarP(&opts.InsecureRegistries, "insecure-registry", "", "Insecure registry using plain HTTP to push and pull. Set it repeatedly for multiple registries.")
 	RootCmd.PersistentFlags().VarP(&opts.SkipTLSVerifyRegistries, "skip-tls-verify-registry", "", "Insecure registry ignoring TLS verify to push and pull. Set it repeatedly for multiple registries.")
+	RootCmd.PersistentFlags().StringVarP(&opts.IgnoreFile, "ignore-file", "", "", "Path to a .dockerignore file to use instead of <dockerfile>.dockerignore or the .dockerignore in the build context.")
+	RootCmd.PersistentFlags().VarP(&opts.IgnorePaths, "ignorepath", "", "Ignore this path when taking snapshots. Set it repeatedly for multiple paths.")
+	RootCmd.PersistentFlags().StringVarP(&opts.ImageRewriteRules, "image-rewrite-rules", "", "", "Path to a JSON file of rules rewriting the images used in FROM and COPY --from.")
+	RootCmd.PersistentFlags().StringVarP(&opts.BaseImageLock, "base-image-lock", "", "", "Path to a file recording the digests of the images used in FROM and COPY --from, which are enforced on later builds.")
+	RootCmd.PersistentFlags().StringVarP(&opts.BaseImageLockMode, "base-image-lock-mode", "", constants.BaseImageLockModeStrict, "What to do when an image no longer matches the digest in --base-image-lock: strict (fail), warn (use the locked digest) or update (update the lock).")
+	RootCmd.PersistentFlags().StringVarP(&opts.CustomPlatform, "custom-platform", "", "", "Platform to select from multi-platform base images and to set in the image config, of the form os/arch[/variant], e.g. linux/arm64/v8.")
+	RootCmd.PersistentFlags().StringVarP(&opts.RegistryCredentials, "registry-credentials", "", "", "Path to a docker config.json, or its directory, with credentials and credential helpers for registries, used before the default docker config.")
+	RootCmd.PersistentFlags().VarP(&opts.RegistryAuths, "registry-auth", "", "Credentials for a registry, of the form registry=user:token. Set it repeatedly for multiple registries.")
+	RootCmd.PersistentFlags().VarP(&opts.RegistryCertificates, "registry-certificate", "", "CA bundle to trust for a registry, of the form registry=/path/to/ca.pem. Set it repeatedly for multiple registries.")
+	RootCmd.PersistentFlags().VarP(&opts.RegistryClientCerts, "registry-client-cert", "", "Client certificate to present to a registry, of the form registry=/path/to/cert.pem,/path/to/key.pem. Set it repeatedly for multiple registries.")
+	RootCmd.PersistentFlags().VarP(&opts.RegistryMirrors, "registry-mirror", "", "Registry mirror to try before the original registry when pulling images, of the form registry=mirror, e.g. docker.io=mirror.gcr.io. Set it repeatedly for multiple mirrors.")
 }
 
 // addHiddenFlags marks certain flags as hidden from the executor help text
@@ -174,6 +253,12 @@ func cacheFlagsValid() error {
 
 // resolveDockerfilePath resolves the Dockerfile path to an absolute path
 func resolveDockerfilePath() error {
+	if opts.DockerfileContent != "" {
+		return writeDockerfile(strings.NewReader(opts.DockerfileContent))
+	}
+	if opts.DockerfilePath == "-" {
+		return writeDockerfile(os.Stdin)
+	}
 	if isURL(opts.DockerfilePath) {
 		return nil
 	}
@@ -200,10 +285,48 @@ func resolveDockerfilePath() error {
 // copy Dockerfile to /kaniko/Dockerfile so that if it's specified in the .dockerignore
 // it won't be copied into the image
 func copyDockerfile() error {
-	if _, err := util.CopyFile(opts.DockerfilePath, constants.DockerfilePath, ""); err != nil {
+	if _, err := util.CopyFile(opts.DockerfilePath, dockerfilePath, ""); err != nil {
 		return errors.Wrap(err, "copying dockerfile")
 	}
-	opts.DockerfilePath = constants.DockerfilePath
+	// <dockerfile>.dockerignore has to be resolved against the original location
+	// of the Dockerfile, since the copy loses its directory and name
+	resolveIgnoreFile()
+	opts.DockerfilePath = dockerfilePath
+	return nil
+}
+
+// resolveIgnoreFile sets the ignore file to <dockerfile>.dockerignore if it exists and --ignore-file isn't set.
+// Otherwise the .dockerignore in the build context is used, if any.
+func resolveIgnoreFile() {
+	if ignore := opts.DockerfilePath + constants.Dockerignore; opts.IgnoreFile == "" && util.FilepathExists(ignore) {
+		opts.IgnoreFile = ignore
+	}
+}
+
+// addIgnorePaths excludes the paths set with --ignorepath from the snapshots of every stage
+func addIgnorePaths() {
+	for _, p := range opts.IgnorePaths {
+		util.AddToBaseWhitelist(util.WhitelistEntry{
+			Path:            filepath.Clean(p),
+			PrefixMatchOnly: false,
+		})
+	}
+}
+
+// writeDockerfile writes a Dockerfile passed on stdin or inline to /kaniko/Dockerfile,
+// so it can be read more than once during the build
+func writeDockerfile(r io.Reader) error {
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "reading dockerfile")
+	}
+	if err := os.MkdirAll(filepath.Dir(dockerfilePath), 0755); err != nil {
+		return errors.Wrap(err, "creating dockerfile directory")
+	}
+	if err := ioutil.WriteFile(dockerfilePath, contents, 0644); err != nil {
+		return errors.Wrap(err, "writing dockerfile")
+	}
+	opts.DockerfilePath = dockerfilePath
 	return nil
 }
 
@@ -245,6 +368,10 @@ func resolveRelativePaths() error {
 		&opts.TarPath,
 		&opts.DigestFile,
 		&opts.ImageNameDigestFile,
+		&opts.LayerReport,
+		&opts.IgnoreFile,
+		&opts.ImageRewriteRules,
+		&opts.BaseImageLock,
 	}
 
 	for _, p := range optsPaths {
@@ -261,6 +388,23 @@ func resolveRelativePaths() error {
 		}
 		logrus.Debugf("Resolved relative path %s to %s", relp, *p)
 	}
+
+	// The registry certificates are given as registry=path or registry=cert,key
+	for _, registries := range []map[string][]string{opts.RegistryCertificates, opts.RegistryClientCerts} {
+		for _, values := range registries {
+			for i, value := range values {
+				paths := strings.Split(value, ",")
+				for j, path := range paths {
+					abs, err := filepath.Abs(path)
+					if err != nil {
+						return errors.Wrapf(err, "Couldn't resolve relative path %s to an absolute path", path)
+					}
+					paths[j] = abs
+				}
+				values[i] = strings.Join(paths, ",")
+			}
+		}
+	}
 	return nil
 }
 
@@ -17,8 +17,13 @@ limitations under the License.
 package cmd
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/GoogleContainerTools/kaniko/testutil"
 )
 
@@ -97,3 +102,118 @@ func TestIsUrl(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveIgnoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ignore-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := testutil.SetupFiles(dir, map[string]string{
+		"app/Dockerfile":              "FROM scratch",
+		"app/Dockerfile.dockerignore": "foo",
+		"web/Dockerfile":              "FROM scratch",
+		".dockerignore":               "bar",
+		"custom.dockerignore":         "baz",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		dockerfile  string
+		ignoreFile  string
+		expected    string
+	}{
+		{
+			description: "next to the dockerfile",
+			dockerfile:  filepath.Join(dir, "app/Dockerfile"),
+			expected:    filepath.Join(dir, "app/Dockerfile.dockerignore"),
+		},
+		{
+			description: "falls back to the build context",
+			dockerfile:  filepath.Join(dir, "web/Dockerfile"),
+			expected:    "",
+		},
+		{
+			description: "explicit ignore file",
+			dockerfile:  filepath.Join(dir, "app/Dockerfile"),
+			ignoreFile:  filepath.Join(dir, "custom.dockerignore"),
+			expected:    filepath.Join(dir, "custom.dockerignore"),
+		},
+	}
+	original := opts
+	defer func() { opts = original }()
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			opts = &config.KanikoOptions{DockerfilePath: tt.dockerfile, IgnoreFile: tt.ignoreFile}
+			resolveIgnoreFile()
+			testutil.CheckDeepEqual(t, tt.expected, opts.IgnoreFile)
+		})
+	}
+}
+
+func TestAddIgnorePaths(t *testing.T) {
+	original := opts
+	defer func() { opts = original }()
+	opts = &config.KanikoOptions{}
+	if err := opts.IgnorePaths.Set("/opt/ignored/"); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.CheckDeepEqual(t, false, util.CheckWhitelist("/opt/ignored/file"))
+	addIgnorePaths()
+	testutil.CheckDeepEqual(t, true, util.CheckWhitelist("/opt/ignored"))
+	testutil.CheckDeepEqual(t, true, util.CheckWhitelist("/opt/ignored/file"))
+	testutil.CheckDeepEqual(t, false, util.CheckWhitelist("/opt/other"))
+}
+
+func TestResolveDockerfilePath_Inline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stdin, err := ioutil.TempFile(dir, "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if _, err := stdin.WriteString("FROM scratch\nCOPY stdin /\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdin.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	originalOpts, originalPath, originalStdin := opts, dockerfilePath, os.Stdin
+	defer func() { opts, dockerfilePath, os.Stdin = originalOpts, originalPath, originalStdin }()
+	dockerfilePath = filepath.Join(dir, "kaniko", "Dockerfile")
+	os.Stdin = stdin
+
+	tests := []struct {
+		description string
+		opts        *config.KanikoOptions
+		expected    string
+	}{
+		{
+			description: "dockerfile content",
+			opts:        &config.KanikoOptions{DockerfileContent: "FROM scratch\nCOPY content /\n"},
+			expected:    "FROM scratch\nCOPY content /\n",
+		},
+		{
+			description: "stdin",
+			opts:        &config.KanikoOptions{DockerfilePath: "-"},
+			expected:    "FROM scratch\nCOPY stdin /\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			opts = tt.opts
+			testutil.CheckError(t, false, resolveDockerfilePath())
+			testutil.CheckDeepEqual(t, dockerfilePath, opts.DockerfilePath)
+			contents, err := ioutil.ReadFile(dockerfilePath)
+			testutil.CheckErrorAndDeepEqual(t, false, err, tt.expected, string(contents))
+		})
+	}
+}
@@ -49,6 +49,11 @@ var RootCmd = &cobra.Command{
 		if len(opts.Images) == 0 {
 			return errors.New("You must select at least one image to cache")
 		}
+		if opts.CustomPlatform != "" {
+			if _, err := config.ParsePlatform(opts.CustomPlatform); err != nil {
+				return err
+			}
+		}
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -70,6 +75,7 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().StringVarP(&opts.CacheDir, "cache-dir", "c", "/cache", "Directory of the cache.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Force, "force", "f", false, "Force cache overwriting.")
 	RootCmd.PersistentFlags().DurationVarP(&opts.CacheTTL, "cache-ttl", "", time.Hour*336, "Cache timeout in hours. Defaults to two weeks.")
+	RootCmd.PersistentFlags().StringVarP(&opts.CustomPlatform, "custom-platform", "", "", "Platform of the images to cache from multi-platform images, of the form os/arch[/variant], e.g. linux/arm64/v8.")
 }
 
 // addHiddenFlags marks certain flags as hidden from the executor help text
@@ -17,14 +17,18 @@ limitations under the License.
 package buildcontext
 
 import (
+	"net/http"
 	"os"
 	"path/filepath"
 
 	"cloud.google.com/go/storage"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/transport"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 // GCS struct for Google Cloud Storage processing
@@ -57,7 +61,12 @@ func unpackTarFromGCSBucket(bucketName, item, directory string) error {
 // It returns the path to the tar file
 func getTarFromBucket(bucketName, item, directory string) (string, error) {
 	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
+	// Authenticate on top of kaniko's transport rather than the shared default one
+	tr, err := htransport.NewTransport(ctx, transport.NewDefault(), option.WithScopes(storage.ScopeReadOnly))
+	if err != nil {
+		return "", err
+	}
+	client, err := storage.NewClient(ctx, option.WithHTTPClient(&http.Client{Transport: tr}))
 	if err != nil {
 		return "", err
 	}
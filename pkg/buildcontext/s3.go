@@ -22,6 +22,7 @@ import (
 	"strings"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/transport"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -39,6 +40,9 @@ func (s *S3) UnpackTarFromBuildContext() (string, error) {
 	bucket, item := util.GetBucketAndItem(s.context)
 	option := session.Options{
 		SharedConfigState: session.SharedConfigEnable,
+		Config: aws.Config{
+			HTTPClient: transport.DefaultClient,
+		},
 	}
 	endpoint := os.Getenv(constants.S3EndpointEnv)
 	forcePath := false
@@ -46,10 +50,8 @@ func (s *S3) UnpackTarFromBuildContext() (string, error) {
 		forcePath = true
 	}
 	if endpoint != "" {
-		option.Config = aws.Config{
-			Endpoint:         aws.String(endpoint),
-			S3ForcePathStyle: aws.Bool(forcePath),
-		}
+		option.Config.Endpoint = aws.String(endpoint)
+		option.Config.S3ForcePathStyle = aws.Bool(forcePath)
 	}
 	sess, err := session.NewSessionWithOptions(option)
 	if err != nil {
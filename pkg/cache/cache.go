@@ -17,9 +17,7 @@ limitations under the License.
 package cache
 
 import (
-	"crypto/tls"
 	"fmt"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -27,6 +25,7 @@ import (
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/creds"
+	"github.com/GoogleContainerTools/kaniko/pkg/transport"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
@@ -45,7 +44,8 @@ type RegistryCache struct {
 	Opts *config.KanikoOptions
 }
 
-// RetrieveLayer retrieves a layer from the cache given the cache key ck.
+// RetrieveLayer retrieves a layer from the cache given the cache key ck,
+// trying the mirrors configured for the registry of the cache first
 func (rc *RegistryCache) RetrieveLayer(ck string) (v1.Image, error) {
 	cache, err := Destination(rc.Opts, ck)
 	if err != nil {
@@ -58,37 +58,52 @@ func (rc *RegistryCache) RetrieveLayer(ck string) (v1.Image, error) {
 		return nil, errors.Wrap(err, fmt.Sprintf("getting reference for %s", cache))
 	}
 
-	registryName := cacheRef.Repository.Registry.Name()
-	if rc.Opts.Insecure || rc.Opts.InsecureRegistries.Contains(registryName) {
+	mirrors, err := config.MirrorReferences(cacheRef, rc.Opts.RegistryMirrors)
+	if err != nil {
+		return nil, err
+	}
+	for _, mirror := range mirrors {
+		img, err := rc.retrieveLayer(mirror)
+		if err == nil {
+			logrus.Infof("Retrieved cached layer %s from mirror %s", cache, mirror)
+			return img, nil
+		}
+		logrus.Debugf("Failed to retrieve cached layer %s from mirror %s, falling back: %v", cache, mirror, err)
+	}
+	return rc.retrieveLayer(cacheRef)
+}
+
+func (rc *RegistryCache) retrieveLayer(ref name.Reference) (v1.Image, error) {
+	registryName := ref.Context().RegistryStr()
+	if tag, ok := ref.(name.Tag); ok && (rc.Opts.Insecure || rc.Opts.InsecureRegistries.Contains(registryName)) {
 		newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
 		if err != nil {
 			return nil, err
 		}
-		cacheRef.Repository.Registry = newReg
+		tag.Repository.Registry = newReg
+		ref = tag
 	}
 
-	tr := http.DefaultTransport.(*http.Transport)
-	if rc.Opts.SkipTLSVerifyRegistries.Contains(registryName) {
-		tr.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+	tr, err := transport.New(rc.Opts, registryName, rc.Opts.SkipTLSVerifyRegistries.Contains(registryName))
+	if err != nil {
+		return nil, err
 	}
 
-	img, err := remote.Image(cacheRef, remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain()))
+	img, err := remote.Image(ref, remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain()))
 	if err != nil {
 		return nil, err
 	}
 
 	cf, err := img.ConfigFile()
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("retrieving config file for %s", cache))
+		return nil, errors.Wrap(err, fmt.Sprintf("retrieving config file for %s", ref))
 	}
 
 	expiry := cf.Created.Add(rc.Opts.CacheTTL)
 	// Layer is stale, rebuild it.
 	if expiry.Before(time.Now()) {
-		logrus.Infof("Cache entry expired: %s", cache)
-		return nil, fmt.Errorf("Cache entry expired: %s", cache)
+		logrus.Infof("Cache entry expired: %s", ref)
+		return nil, fmt.Errorf("Cache entry expired: %s", ref)
 	}
 
 	// Force the manifest to be populated
@@ -103,6 +118,9 @@ func (rc *RegistryCache) RetrieveLayer(ck string) (v1.Image, error) {
 func Destination(opts *config.KanikoOptions, cacheKey string) (string, error) {
 	cache := opts.CacheRepo
 	if cache == "" {
+		if len(opts.Destinations) == 0 {
+			return "", errors.New("no cache repo or destination to infer it from")
+		}
 		destination := opts.Destinations[0]
 		destRef, err := name.NewTag(destination, name.WeakValidation)
 		if err != nil {
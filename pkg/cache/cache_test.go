@@ -0,0 +1,116 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+// fakeRegistry serves the manifest and config of img for any repository and tag,
+// or 404s if img is nil, and counts the requests it gets
+func fakeRegistry(t *testing.T, img v1.Image, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case img == nil:
+			w.WriteHeader(http.StatusNotFound)
+		case strings.Contains(r.URL.Path, "/manifests/"):
+			m, err := img.RawManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			mt, err := img.MediaType()
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Header().Set("Content-Type", string(mt))
+			w.Write(m)
+		case strings.Contains(r.URL.Path, "/blobs/"):
+			cf, err := img.RawConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(cf)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestRetrieveLayer_Mirrors(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = mutate.CreatedAt(img, v1.Time{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		mirrorHasLayer bool
+	}{
+		{name: "found on the mirror", mirrorHasLayer: true},
+		{name: "falls back to the origin"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var originRequests, mirrorRequests int
+			origin := fakeRegistry(t, img, &originRequests)
+			defer origin.Close()
+			var mirrorImg v1.Image
+			if test.mirrorHasLayer {
+				mirrorImg = img
+			}
+			mirror := fakeRegistry(t, mirrorImg, &mirrorRequests)
+			defer mirror.Close()
+
+			originHost := strings.TrimPrefix(origin.URL, "http://")
+			mirrorHost := strings.TrimPrefix(mirror.URL, "http://")
+			rc := &RegistryCache{Opts: &config.KanikoOptions{
+				CacheRepo:          originHost + "/project/cache",
+				CacheOptions:       config.CacheOptions{CacheTTL: time.Hour},
+				InsecureRegistries: []string{originHost, mirrorHost},
+				RegistryMirrors:    map[string][]string{originHost: {mirrorHost}},
+			}}
+			actual, err := rc.RetrieveLayer("key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := img.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			digest, err := actual.Digest()
+			testutil.CheckErrorAndDeepEqual(t, false, err, expected, digest)
+			testutil.CheckDeepEqual(t, true, mirrorRequests > 0)
+			testutil.CheckDeepEqual(t, !test.mirrorHasLayer, originRequests > 0)
+		})
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -32,17 +33,24 @@ type AddCommand struct {
 	BaseCommand
 	cmd           *instructions.AddCommand
 	buildcontext  string
+	flags         map[string]string
 	snapshotFiles []string
+	// remoteVersions caches the commit or version of each remote source,
+	// so it is only looked up once per command
+	remoteVersions map[string]string
 }
 
 // ExecuteCommand executes the ADD command
 // Special stuff about ADD:
-// 	1. If <src> is a remote file URL:
-// 		- destination will have permissions of 0600
-// 		- If remote file has HTTP Last-Modified header, we set the mtime of the file to that timestamp
-// 		- If dest doesn't end with a slash, the filepath is inferred to be <dest>/<filename>
-// 	2. If <src> is a local tar archive:
-// 		- it is unpacked at the dest, as 'tar -x' would
+//  1. If <src> is a remote file URL:
+//     - destination will have permissions of 0600
+//     - If remote file has HTTP Last-Modified header, we set the mtime of the file to that timestamp
+//     - If dest doesn't end with a slash, the filepath is inferred to be <dest>/<filename>
+//     - If --checksum is set, the downloaded file must match it
+//  2. If <src> is a git repository, e.g. https://github.com/org/repo.git#ref:
+//     - the ref is checked out and the repository is copied into dest, without its .git directory
+//  3. If <src> is a local tar archive:
+//     - it is unpacked at the dest, as 'tar -x' would
 func (a *AddCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
 	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
 
@@ -51,21 +59,39 @@ func (a *AddCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bui
 		return err
 	}
 
+	checksum, err := a.checksum(srcs, replacementEnvs)
+	if err != nil {
+		return err
+	}
+
 	var unresolvedSrcs []string
 	// If any of the sources are local tar archives:
 	// 	1. Unpack them to the specified destination
+	// If any of the sources is a git repository:
+	//	1. Check it out and copy it to the specified dest
 	// If any of the sources is a remote file URL:
 	//	1. Download and copy it to the specified dest
 	// Else, add to the list of unresolved sources
 	for _, src := range srcs {
 		fullPath := filepath.Join(a.buildcontext, src)
-		if util.IsSrcRemoteFileURL(src) {
+		if util.IsSrcGitURL(src) {
+			gitDest, err := util.DestinationFilepath("", dest, config.WorkingDir)
+			if err != nil {
+				return err
+			}
+			logrus.Infof("Adding git repository %s to %s", src, gitDest)
+			copiedFiles, err := util.CloneGitRepoToDest(src, gitDest)
+			if err != nil {
+				return err
+			}
+			a.snapshotFiles = append(a.snapshotFiles, copiedFiles...)
+		} else if util.IsSrcRemoteFileURL(src) {
 			urlDest, err := util.URLDestinationFilepath(src, dest, config.WorkingDir, replacementEnvs)
 			if err != nil {
 				return err
 			}
 			logrus.Infof("Adding remote URL %s to %s", src, urlDest)
-			if err := util.DownloadFileToDest(src, urlDest); err != nil {
+			if err := util.DownloadFileToDest(src, urlDest, checksum); err != nil {
 				return err
 			}
 			a.snapshotFiles = append(a.snapshotFiles, urlDest)
@@ -124,7 +150,7 @@ func (a *AddCommand) FilesUsedFromContext(config *v1.Config, buildArgs *dockerfi
 
 	files := []string{}
 	for _, src := range srcs {
-		if util.IsSrcRemoteFileURL(src) {
+		if util.IsSrcRemote(src) {
 			continue
 		}
 		if util.IsFileLocalTarArchive(src) {
@@ -138,6 +164,79 @@ func (a *AddCommand) FilesUsedFromContext(config *v1.Config, buildArgs *dockerfi
 	return files, nil
 }
 
+// RemoteSourceKeys returns the checksum given to ADD --checksum, or else the commit of each git source
+// and the ETag or Last-Modified time of each remote file, so the cache is invalidated when they change.
+// Each source is looked up once, later calls reuse the result so every cache key of the command agrees.
+func (a *AddCommand) RemoteSourceKeys(config *v1.Config, buildArgs *dockerfile.BuildArgs) ([]string, error) {
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+
+	srcs, _, err := util.ResolveEnvAndWildcards(a.cmd.SourcesAndDest, a.buildcontext, replacementEnvs)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := a.checksum(srcs, replacementEnvs)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != "" {
+		return []string{checksum}, nil
+	}
+
+	var keys []string
+	for _, src := range srcs {
+		if !util.IsSrcGitURL(src) && !util.IsSrcRemoteFileURL(src) {
+			continue
+		}
+		version, err := a.remoteVersion(src)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, version)
+	}
+	return keys, nil
+}
+
+// remoteVersion returns the commit of a git source or the version of a remote file, looking it up only once
+func (a *AddCommand) remoteVersion(src string) (string, error) {
+	if version, ok := a.remoteVersions[src]; ok {
+		return version, nil
+	}
+	var version string
+	if util.IsSrcGitURL(src) {
+		commit, err := util.GitRemoteCommit(src)
+		if err != nil {
+			return "", errors.Wrapf(err, "resolving commit of %s", src)
+		}
+		version = commit
+	} else {
+		v, err := util.RemoteFileVersion(src)
+		if err != nil {
+			return "", err
+		}
+		if v == "" {
+			logrus.Warnf("%s has neither an ETag nor a Last-Modified header, changes to it won't invalidate the cache", src)
+		}
+		version = v
+	}
+	if a.remoteVersions == nil {
+		a.remoteVersions = map[string]string{}
+	}
+	a.remoteVersions[src] = version
+	return version, nil
+}
+
+// checksum returns the checksum given to ADD --checksum, which requires a single remote file source
+func (a *AddCommand) checksum(srcs []string, replacementEnvs []string) (string, error) {
+	checksum, ok := a.flags["checksum"]
+	if !ok {
+		return "", nil
+	}
+	if len(srcs) != 1 || util.IsSrcGitURL(srcs[0]) || !util.IsSrcRemoteFileURL(srcs[0]) {
+		return "", errors.New("--checksum can only be used with a single remote URL source")
+	}
+	return util.ResolveEnvironmentReplacement(checksum, replacementEnvs, false)
+}
+
 func (a *AddCommand) MetadataOnly() bool {
 	return false
 }
@@ -0,0 +1,57 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+)
+
+func TestAddCommand_RemoteSourceKeys_LookedUpOnce(t *testing.T) {
+	// source validation also GETs the URL, only the HEAD version lookups are counted
+	lookups := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			lookups++
+		}
+		w.Header().Set("ETag", `"v1"`)
+	}))
+	defer server.Close()
+
+	cmd := &AddCommand{
+		cmd: &instructions.AddCommand{
+			SourcesAndDest: []string{server.URL + "/file", "/dest/"},
+		},
+	}
+	cfg := &v1.Config{}
+	buildArgs := dockerfile.NewBuildArgs([]string{})
+
+	first, err := cmd.RemoteSourceKeys(cfg, buildArgs)
+	testutil.CheckError(t, false, err)
+	second, err := cmd.RemoteSourceKeys(cfg, buildArgs)
+	testutil.CheckError(t, false, err)
+
+	testutil.CheckDeepEqual(t, []string{`"v1"`}, first)
+	testutil.CheckDeepEqual(t, first, second)
+	testutil.CheckDeepEqual(t, 1, lookups)
+}
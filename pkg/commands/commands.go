@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -57,12 +58,28 @@ type DockerCommand interface {
 	ShouldCacheOutput() bool
 }
 
-func GetCommand(cmd instructions.Command, buildcontext string) (DockerCommand, error) {
+// HeredocCommand is implemented by commands which can use heredocs from the Dockerfile,
+// whose contents need to be part of the cache key
+type HeredocCommand interface {
+	Heredocs() []config.Heredoc
+}
+
+// RemoteSourceCommand is implemented by commands which use sources from outside the build context,
+// such as ADD from a URL
+type RemoteSourceCommand interface {
+	// RemoteSourceKeys returns keys identifying the current version of the remote sources,
+	// so that the cache is invalidated when they change
+	RemoteSourceKeys(config *v1.Config, buildArgs *dockerfile.BuildArgs) ([]string, error)
+}
+
+// GetCommand returns the kaniko command for cmd, whose extensions are the parts of it
+// the vendored buildkit parser doesn't support, such as heredocs and some flags
+func GetCommand(cmd instructions.Command, buildcontext string, extensions config.Extensions) (DockerCommand, error) {
 	switch c := cmd.(type) {
 	case *instructions.RunCommand:
-		return &RunCommand{cmd: c}, nil
+		return &RunCommand{cmd: c, heredocs: extensions.Heredocs}, nil
 	case *instructions.CopyCommand:
-		return &CopyCommand{cmd: c, buildcontext: buildcontext}, nil
+		return &CopyCommand{cmd: c, buildcontext: buildcontext, heredocs: extensions.Heredocs, flags: extensions.Flags}, nil
 	case *instructions.ExposeCommand:
 		return &ExposeCommand{cmd: c}, nil
 	case *instructions.EnvCommand:
@@ -70,7 +87,7 @@ func GetCommand(cmd instructions.Command, buildcontext string) (DockerCommand, e
 	case *instructions.WorkdirCommand:
 		return &WorkdirCommand{cmd: c}, nil
 	case *instructions.AddCommand:
-		return &AddCommand{cmd: c, buildcontext: buildcontext}, nil
+		return &AddCommand{cmd: c, buildcontext: buildcontext, flags: extensions.Flags}, nil
 	case *instructions.CmdCommand:
 		return &CmdCommand{cmd: c}, nil
 	case *instructions.EntrypointCommand:
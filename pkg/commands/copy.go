@@ -20,11 +20,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
@@ -36,6 +39,8 @@ type CopyCommand struct {
 	BaseCommand
 	cmd           *instructions.CopyCommand
 	buildcontext  string
+	heredocs      []config.Heredoc
+	flags         map[string]string
 	snapshotFiles []string
 }
 
@@ -47,7 +52,33 @@ func (c *CopyCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bu
 
 	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
 
-	srcs, dest, err := util.ResolveEnvAndWildcards(c.cmd.SourcesAndDest, c.buildcontext, replacementEnvs)
+	mode, useMode, err := chmodFromFlag(c.flags, replacementEnvs)
+	if err != nil {
+		return err
+	}
+	if err := c.copySources(config, replacementEnvs); err != nil {
+		return err
+	}
+	if useMode {
+		return chmodFiles(c.snapshotFiles, mode)
+	}
+	return nil
+}
+
+func (c *CopyCommand) copySources(config *v1.Config, replacementEnvs []string) error {
+	sourcesAndDest, heredocs := splitHeredocSources(c.cmd, c.heredocs)
+	if len(heredocs) > 0 {
+		copiedFiles, err := copyHeredocs(heredocs, sourcesAndDest.Dest(), c.cmd.Chown, config, replacementEnvs)
+		if err != nil {
+			return err
+		}
+		c.snapshotFiles = append(c.snapshotFiles, copiedFiles...)
+		if len(sourcesAndDest) == 1 {
+			return nil
+		}
+	}
+
+	srcs, dest, err := util.ResolveEnvAndWildcards(sourcesAndDest, c.buildcontext, replacementEnvs)
 	if err != nil {
 		return err
 	}
@@ -121,7 +152,18 @@ func (c *CopyCommand) String() string {
 }
 
 func (c *CopyCommand) FilesUsedFromContext(config *v1.Config, buildArgs *dockerfile.BuildArgs) ([]string, error) {
-	return copyCmdFilesUsedFromContext(config, buildArgs, c.cmd, c.buildcontext)
+	return copyCmdFilesUsedFromContext(config, buildArgs, c.cmd, c.heredocs, c.buildcontext)
+}
+
+// Heredocs returns the heredocs used as sources of the COPY instruction
+func (c *CopyCommand) Heredocs() []config.Heredoc {
+	return c.heredocs
+}
+
+// Link returns true if the COPY instruction was given --link, in which case the layer
+// it creates doesn't depend on the layers before it
+func (c *CopyCommand) Link() bool {
+	return copyLink(c.flags)
 }
 
 func (c *CopyCommand) MetadataOnly() bool {
@@ -143,6 +185,8 @@ func (c *CopyCommand) CacheCommand(img v1.Image) DockerCommand {
 		img:          img,
 		cmd:          c.cmd,
 		buildcontext: c.buildcontext,
+		heredocs:     c.heredocs,
+		flags:        c.flags,
 		extractFn:    util.ExtractFile,
 	}
 }
@@ -157,6 +201,8 @@ type CachingCopyCommand struct {
 	extractedFiles []string
 	cmd            *instructions.CopyCommand
 	buildcontext   string
+	heredocs       []config.Heredoc
+	flags          map[string]string
 	extractFn      util.ExtractFunction
 }
 
@@ -178,7 +224,15 @@ func (cr *CachingCopyCommand) ExecuteCommand(config *v1.Config, buildArgs *docke
 }
 
 func (cr *CachingCopyCommand) FilesUsedFromContext(config *v1.Config, buildArgs *dockerfile.BuildArgs) ([]string, error) {
-	return copyCmdFilesUsedFromContext(config, buildArgs, cr.cmd, cr.buildcontext)
+	return copyCmdFilesUsedFromContext(config, buildArgs, cr.cmd, cr.heredocs, cr.buildcontext)
+}
+
+func (cr *CachingCopyCommand) Heredocs() []config.Heredoc {
+	return cr.heredocs
+}
+
+func (cr *CachingCopyCommand) Link() bool {
+	return copyLink(cr.flags)
 }
 
 func (cr *CachingCopyCommand) FilesToSnapshot() []string {
@@ -216,17 +270,23 @@ func resolveIfSymlink(destPath string) (string, error) {
 
 func copyCmdFilesUsedFromContext(
 	config *v1.Config, buildArgs *dockerfile.BuildArgs, cmd *instructions.CopyCommand,
-	buildcontext string,
+	heredocs []config.Heredoc, buildcontext string,
 ) ([]string, error) {
 	// We don't use the context if we're performing a copy --from.
 	if cmd.From != "" {
 		return nil, nil
 	}
 
+	// Heredocs are part of the Dockerfile, not the context.
+	sourcesAndDest, _ := splitHeredocSources(cmd, heredocs)
+	if len(sourcesAndDest) == 1 {
+		return nil, nil
+	}
+
 	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
 
 	srcs, _, err := util.ResolveEnvAndWildcards(
-		cmd.SourcesAndDest, buildcontext, replacementEnvs,
+		sourcesAndDest, buildcontext, replacementEnvs,
 	)
 	if err != nil {
 		return nil, err
@@ -242,3 +302,149 @@ func copyCmdFilesUsedFromContext(
 
 	return files, nil
 }
+
+// splitHeredocSources returns the sources and destination of cmd without any heredocs,
+// and the heredocs used as sources
+func splitHeredocSources(cmd *instructions.CopyCommand, heredocs []config.Heredoc) (instructions.SourcesAndDest, []config.Heredoc) {
+	if len(heredocs) == 0 {
+		return cmd.SourcesAndDest, nil
+	}
+	byName := map[string]config.Heredoc{}
+	for _, h := range heredocs {
+		byName[h.Name] = h
+	}
+	var sourcesAndDest instructions.SourcesAndDest
+	var used []config.Heredoc
+	for _, src := range cmd.SourcesAndDest.Sources() {
+		if name, ok := dockerfile.HeredocName(src); ok {
+			if h, ok := byName[name]; ok {
+				used = append(used, h)
+				continue
+			}
+		}
+		sourcesAndDest = append(sourcesAndDest, src)
+	}
+	return append(sourcesAndDest, cmd.SourcesAndDest.Dest()), used
+}
+
+// copyHeredocs creates a file at dest from each heredoc, owned by chown if set.
+// If dest is a directory, the files are named after the heredocs.
+func copyHeredocs(heredocs []config.Heredoc, dest, chown string, config *v1.Config, replacementEnvs []string) ([]string, error) {
+	dest, err := util.ResolveEnvironmentReplacement(dest, replacementEnvs, true)
+	if err != nil {
+		return nil, err
+	}
+	var uid, gid uint32
+	if chown != "" {
+		chown, err = util.ResolveEnvironmentReplacement(chown, replacementEnvs, false)
+		if err != nil {
+			return nil, err
+		}
+		uid, gid, err = util.GetUIDAndGIDFromString(chown)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving chown %s", chown)
+		}
+	}
+	if len(heredocs) > 1 && !util.IsDestDir(dest) {
+		return nil, errors.New("when specifying multiple sources in a COPY command, destination must be a directory and end in '/'")
+	}
+	cwd := config.WorkingDir
+	if cwd == "" {
+		cwd = constants.RootDir
+	}
+	var copiedFiles []string
+	for _, h := range heredocs {
+		destPath, err := util.DestinationFilepath(h.Name, dest, cwd)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Debugf("Creating file %s from heredoc %s", destPath, h.Name)
+		content, err := expandHeredoc(h, replacementEnvs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding heredoc %s", h.Name)
+		}
+		if err := util.CreateFile(destPath, strings.NewReader(content), 0644, uid, gid); err != nil {
+			return nil, err
+		}
+		copiedFiles = append(copiedFiles, destPath)
+	}
+	return copiedFiles, nil
+}
+
+// expandHeredoc returns the content of h with variables replaced from envs, the same way
+// they are in other instructions, unless the delimiter of h was quoted.
+// Unlike in other instructions, quotes and backslashes are kept, except for \$.
+func expandHeredoc(h config.Heredoc, envs []string) (string, error) {
+	if !h.Expand {
+		return h.Content, nil
+	}
+	// Escape the quotes and backslashes so the shell lexer keeps them
+	var escaped strings.Builder
+	for i := 0; i < len(h.Content); i++ {
+		switch c := h.Content[i]; {
+		case c == '\\' && i+1 < len(h.Content) && h.Content[i+1] == '$':
+			escaped.WriteString(`\$`)
+			i++
+		case c == '\\' || c == '\'' || c == '"':
+			escaped.WriteByte('\\')
+			escaped.WriteByte(c)
+		default:
+			escaped.WriteByte(c)
+		}
+	}
+	return util.ResolveEnvironmentReplacement(escaped.String(), envs, false)
+}
+
+// copyLink returns true if COPY --link was set in flags
+func copyLink(flags map[string]string) bool {
+	v, ok := flags["link"]
+	if !ok {
+		return false
+	}
+	link, _ := strconv.ParseBool(v)
+	return link
+}
+
+// chmodFromFlag returns the octal mode given to COPY --chmod in flags, and false if it wasn't set
+func chmodFromFlag(flags map[string]string, replacementEnvs []string) (os.FileMode, bool, error) {
+	v, ok := flags["chmod"]
+	if !ok {
+		return 0, false, nil
+	}
+	v, err := util.ResolveEnvironmentReplacement(v, replacementEnvs, false)
+	if err != nil {
+		return 0, false, err
+	}
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, false, fmt.Errorf("invalid --chmod %s: must be an octal mode such as 0755", v)
+	}
+	fileMode := os.FileMode(mode) & os.ModePerm
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode, true, nil
+}
+
+// chmodFiles sets the permission bits of the copied files and directories to mode, leaving symlinks alone
+func chmodFiles(files []string, mode os.FileMode) error {
+	for _, f := range files {
+		fi, err := os.Lstat(f)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		if err := os.Chmod(f, mode); err != nil {
+			return errors.Wrapf(err, "changing permissions of %s", f)
+		}
+	}
+	return nil
+}
@@ -25,7 +25,9 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/GoogleContainerTools/kaniko/testutil"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
@@ -168,6 +170,100 @@ func copySetUpBuildArgs() *dockerfile.BuildArgs {
 	return buildArgs
 }
 
+func TestCopyExecuteCmd_Heredoc(t *testing.T) {
+	tempDir := setupTestTemp()
+	defer os.RemoveAll(tempDir)
+
+	stages, _, extensions, err := dockerfile.Parse([]byte(`FROM scratch
+COPY <<EOF <<"RAW" foo heredocs/
+hello $NAME
+EOF
+hello $NAME
+RAW
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := CopyCommand{
+		cmd:          stages[0].Commands[0].(*instructions.CopyCommand),
+		buildcontext: tempDir,
+		heredocs:     extensions[stages[0].Commands[0]].Heredocs,
+	}
+	cfg := &v1.Config{
+		Env:        []string{"NAME=kaniko"},
+		WorkingDir: tempDir,
+	}
+
+	files, err := cmd.FilesUsedFromContext(cfg, copySetUpBuildArgs())
+	testutil.CheckErrorAndDeepEqual(t, false, err, []string{filepath.Join(tempDir, "foo")}, files)
+
+	if err := cmd.ExecuteCommand(cfg, copySetUpBuildArgs()); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"EOF": "hello kaniko\n",
+		"RAW": "hello $NAME\n",
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(tempDir, "heredocs", name))
+		testutil.CheckErrorAndDeepEqual(t, false, err, expected, string(contents))
+	}
+	testutil.CheckDeepEqual(t, true, util.FilepathExists(filepath.Join(tempDir, "heredocs", "foo")))
+	testutil.CheckDeepEqual(t, 3, len(cmd.FilesToSnapshot()))
+}
+
+func TestCopyExecuteCmd_Chmod(t *testing.T) {
+	tempDir := setupTestTemp()
+	defer os.RemoveAll(tempDir)
+
+	stages, _, extensions, err := dockerfile.Parse([]byte(`FROM scratch
+COPY --chmod=$MODE foo <<EOF chmod/
+hello
+EOF
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := CopyCommand{
+		cmd:          stages[0].Commands[0].(*instructions.CopyCommand),
+		buildcontext: tempDir,
+		heredocs:     extensions[stages[0].Commands[0]].Heredocs,
+		flags:        extensions[stages[0].Commands[0]].Flags,
+	}
+	cfg := &v1.Config{
+		Env:        []string{"MODE=0750"},
+		WorkingDir: tempDir,
+	}
+	if err := cmd.ExecuteCommand(cfg, copySetUpBuildArgs()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"foo", "EOF"} {
+		fi, err := os.Stat(filepath.Join(tempDir, "chmod", name))
+		testutil.CheckErrorAndDeepEqual(t, false, err, os.FileMode(0750), fi.Mode().Perm())
+	}
+}
+
+func Test_chmodFromFlag(t *testing.T) {
+	tests := []struct {
+		chmod       string
+		mode        os.FileMode
+		shouldError bool
+	}{
+		{chmod: "0644", mode: 0644},
+		{chmod: "755", mode: 0755},
+		{chmod: "4755", mode: 0755 | os.ModeSetuid},
+		{chmod: "u+x", shouldError: true},
+		{chmod: "0999", shouldError: true},
+		{chmod: "17777", shouldError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.chmod, func(t *testing.T) {
+			mode, ok, err := chmodFromFlag(map[string]string{"chmod": test.chmod}, nil)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldError, err, test.mode, mode)
+			testutil.CheckDeepEqual(t, !test.shouldError, ok)
+		})
+	}
+}
+
 func Test_resolveIfSymlink(t *testing.T) {
 	type testCase struct {
 		destPath     string
@@ -364,3 +460,37 @@ func Test_CachingCopyCommand_ExecuteCommand(t *testing.T) {
 		})
 	}
 }
+
+func Test_expandHeredoc(t *testing.T) {
+	envs := []string{"NAME=kaniko", "EMPTY="}
+	tests := []struct {
+		name     string
+		content  string
+		expand   bool
+		expected string
+	}{
+		{
+			name:     "variables",
+			content:  "$NAME ${NAME} $EMPTY$MISSING ${MISSING:-default}\n",
+			expand:   true,
+			expected: "kaniko kaniko  default\n",
+		},
+		{
+			name:     "quotes and escapes are kept",
+			content:  `echo "$NAME" '$NAME' \$NAME a\b` + "\n",
+			expand:   true,
+			expected: `echo "kaniko" 'kaniko' $NAME a\b` + "\n",
+		},
+		{
+			name:     "quoted delimiter",
+			content:  "$NAME \\$NAME\n",
+			expected: "$NAME \\$NAME\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := expandHeredoc(config.Heredoc{Name: "EOF", Content: test.content, Expand: test.expand}, envs)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, content)
+		})
+	}
+}
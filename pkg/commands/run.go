@@ -18,13 +18,14 @@ package commands
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
-	"strconv"
 	"strings"
 	"syscall"
 
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
@@ -36,7 +37,8 @@ import (
 
 type RunCommand struct {
 	BaseCommand
-	cmd *instructions.RunCommand
+	cmd      *instructions.RunCommand
+	heredocs []config.Heredoc
 }
 
 // for testing
@@ -55,7 +57,28 @@ func (r *RunCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bui
 			shell = append(shell, "/bin/sh", "-c")
 		}
 
-		newCommand = append(shell, strings.Join(r.cmd.CmdLine, " "))
+		cmdLine := strings.Join(r.cmd.CmdLine, " ")
+		heredocs := r.Heredocs()
+		switch {
+		case len(heredocs) == 0:
+			newCommand = append(shell, cmdLine)
+		case isHeredocScript(cmdLine, heredocs):
+			// RUN <<EOF runs the heredoc as a script, directly if it has a shebang
+			script := heredocs[0].Content
+			if !strings.HasPrefix(script, "#!") {
+				newCommand = append(shell, script)
+				break
+			}
+			path, err := writeHeredocScript(script)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(path)
+			newCommand = []string{path}
+		default:
+			// Otherwise the heredocs are passed along to the shell, e.g. RUN python3 <<EOF
+			newCommand = append(shell, heredocShellScript(cmdLine, heredocs))
+		}
 	} else {
 		newCommand = r.cmd.CmdLine
 	}
@@ -73,32 +96,10 @@ func (r *RunCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bui
 
 	// If specified, run the command as a specific user
 	if config.User != "" {
-		userAndGroup := strings.Split(config.User, ":")
-		userStr := userAndGroup[0]
-		var groupStr string
-		if len(userAndGroup) > 1 {
-			groupStr = userAndGroup[1]
-		}
-
-		uidStr, gidStr, err := util.GetUserFromUsername(userStr, groupStr)
+		uid, gid, err := util.GetUIDAndGIDFromString(config.User)
 		if err != nil {
 			return err
 		}
-
-		// uid and gid need to be uint32
-		uid64, err := strconv.ParseUint(uidStr, 10, 32)
-		if err != nil {
-			return err
-		}
-		uid := uint32(uid64)
-		var gid uint32
-		if gidStr != "" {
-			gid64, err := strconv.ParseUint(gidStr, 10, 32)
-			if err != nil {
-				return err
-			}
-			gid = uint32(gid64)
-		}
 		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid}
 	}
 
@@ -122,6 +123,48 @@ func (r *RunCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bui
 	return nil
 }
 
+// Heredocs returns the heredocs following the RUN instruction
+func (r *RunCommand) Heredocs() []config.Heredoc {
+	return r.heredocs
+}
+
+// isHeredocScript returns true if the command line consists of a single heredoc, i.e. RUN <<EOF
+func isHeredocScript(cmdLine string, heredocs []config.Heredoc) bool {
+	name, ok := dockerfile.HeredocName(strings.TrimSpace(cmdLine))
+	return ok && len(heredocs) == 1 && name == heredocs[0].Name
+}
+
+// heredocShellScript appends the bodies of the heredocs to the command line,
+// so the shell can resolve them
+func heredocShellScript(cmdLine string, heredocs []config.Heredoc) string {
+	var script strings.Builder
+	script.WriteString(cmdLine)
+	script.WriteString("\n")
+	for _, h := range heredocs {
+		script.WriteString(h.Content)
+		script.WriteString(h.Name)
+		script.WriteString("\n")
+	}
+	return script.String()
+}
+
+// writeHeredocScript writes a heredoc script with a shebang to an executable file in /kaniko,
+// which isn't included in snapshots
+func writeHeredocScript(script string) (string, error) {
+	f, err := ioutil.TempFile(constants.KanikoDir, "heredoc")
+	if err != nil {
+		return "", errors.Wrap(err, "creating heredoc script")
+	}
+	defer f.Close()
+	if _, err := f.WriteString(script); err != nil {
+		return "", errors.Wrap(err, "writing heredoc script")
+	}
+	if err := f.Chmod(0755); err != nil {
+		return "", errors.Wrap(err, "making heredoc script executable")
+	}
+	return f.Name(), nil
+}
+
 // addDefaultHOME adds the default value for HOME if it isn't already set
 func addDefaultHOME(u string, envs []string) []string {
 	for _, env := range envs {
@@ -166,6 +209,7 @@ func (r *RunCommand) CacheCommand(img v1.Image) DockerCommand {
 	return &CachingRunCommand{
 		img:       img,
 		cmd:       r.cmd,
+		heredocs:  r.heredocs,
 		extractFn: util.ExtractFile,
 	}
 }
@@ -187,6 +231,7 @@ type CachingRunCommand struct {
 	img            v1.Image
 	extractedFiles []string
 	cmd            *instructions.RunCommand
+	heredocs       []config.Heredoc
 	extractFn      util.ExtractFunction
 }
 
@@ -205,6 +250,10 @@ func (cr *CachingRunCommand) ExecuteCommand(config *v1.Config, buildArgs *docker
 	return nil
 }
 
+func (cr *CachingRunCommand) Heredocs() []config.Heredoc {
+	return cr.heredocs
+}
+
 func (cr *CachingRunCommand) FilesToSnapshot() []string {
 	return cr.extractedFiles
 }
@@ -26,6 +26,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
 	"github.com/GoogleContainerTools/kaniko/testutil"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -178,6 +179,20 @@ meow meow meow meow
 	return writer.Bytes(), nil
 }
 
+func Test_heredocShellScript(t *testing.T) {
+	heredocs := []config.Heredoc{
+		{Name: "EOF", Content: "print('hi')\n"},
+		{Name: "END", Content: "a\nb\n"},
+	}
+	testutil.CheckDeepEqual(t, false, isHeredocScript("python3 <<EOF", heredocs[:1]))
+	testutil.CheckDeepEqual(t, true, isHeredocScript("<<EOF", heredocs[:1]))
+	testutil.CheckDeepEqual(t, true, isHeredocScript(`<<-"EOF"`, heredocs[:1]))
+	testutil.CheckDeepEqual(t, false, isHeredocScript("<<EOF", heredocs))
+	testutil.CheckDeepEqual(t,
+		"python3 <<EOF && cat <<END\nprint('hi')\nEOF\na\nb\nEND\n",
+		heredocShellScript("python3 <<EOF && cat <<END", heredocs))
+}
+
 func Test_CachingRunCommand_ExecuteCommand(t *testing.T) {
 	tarContent, err := prepareTarFixture([]string{"foo.txt"})
 	if err != nil {
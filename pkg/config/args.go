@@ -17,8 +17,10 @@ limitations under the License.
 package config
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/docker/go-units"
 	"github.com/sirupsen/logrus"
 )
 
@@ -51,3 +53,55 @@ func (b *multiArg) Contains(v string) bool {
 	}
 	return false
 }
+
+// This type is used to support passing in multiple key=value flags, e.g. --registry-mirror docker.io=mirror.gcr.io
+// Values given for the same key are kept in the order they were passed in
+type keyValueArg map[string][]string
+
+func (a *keyValueArg) String() string {
+	var kvs []string
+	for k, vs := range *a {
+		for _, v := range vs {
+			kvs = append(kvs, k+"="+v)
+		}
+	}
+	return strings.Join(kvs, ",")
+}
+
+func (a *keyValueArg) Set(value string) error {
+	logrus.Debugf("appending to key value args %s", value)
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return fmt.Errorf("%s must be of the form key=value", value)
+	}
+	if *a == nil {
+		*a = keyValueArg{}
+	}
+	(*a)[kv[0]] = append((*a)[kv[0]], kv[1])
+	return nil
+}
+
+func (a *keyValueArg) Type() string {
+	return "key-value-arg type"
+}
+
+// This type is used to support passing in sizes in bytes with units, e.g. --max-layer-size 500MB
+// Units are binary, so 1KB is 1024 bytes
+type sizeArg int64
+
+func (a *sizeArg) String() string {
+	return units.BytesSize(float64(*a))
+}
+
+func (a *sizeArg) Set(value string) error {
+	size, err := units.RAMInBytes(value)
+	if err != nil {
+		return err
+	}
+	*a = sizeArg(size)
+	return nil
+}
+
+func (a *sizeArg) Type() string {
+	return "size-arg type"
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func TestSizeArg(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  int64
+		shouldErr bool
+	}{
+		{value: "1024", expected: 1024},
+		{value: "500MB", expected: 500 * 1024 * 1024},
+		{value: "2g", expected: 2 * 1024 * 1024 * 1024},
+		{value: "1.5KiB", expected: 1536},
+		{value: "lots", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			var a sizeArg
+			err := a.Set(test.value)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, int64(a))
+		})
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// MirrorReferences returns ref as found on each of the mirrors set for its registry with --registry-mirror
+func MirrorReferences(ref name.Reference, registryMirrors map[string][]string) ([]name.Reference, error) {
+	var refs []name.Reference
+	for registry, mirrors := range registryMirrors {
+		reg, err := name.NewRegistry(registry, name.WeakValidation)
+		if err != nil {
+			return nil, err
+		}
+		if reg.RegistryStr() != ref.Context().RegistryStr() {
+			continue
+		}
+		separator := ":"
+		if _, ok := ref.(name.Digest); ok {
+			separator = "@"
+		}
+		for _, mirror := range mirrors {
+			mirrorRef, err := name.ParseReference(mirror+"/"+ref.Context().RepositoryStr()+separator+ref.Identifier(), name.WeakValidation)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, mirrorRef)
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func Test_MirrorReferences(t *testing.T) {
+	mirrors := map[string][]string{
+		"docker.io": {"mirror.internal:5000", "mirror.gcr.io/hub"},
+		"gcr.io":    {"gcr-mirror.internal"},
+	}
+	tests := []struct {
+		image    string
+		expected []string
+	}{
+		{
+			image:    "ubuntu:18.04",
+			expected: []string{"mirror.internal:5000/library/ubuntu:18.04", "mirror.gcr.io/hub/library/ubuntu:18.04"},
+		},
+		{
+			image:    "gcr.io/distroless/base@sha256:7fa7445dfbebae4f4b7ab0e6ef99276e96075ae42584af6286ba080750d6dfe5",
+			expected: []string{"gcr-mirror.internal/distroless/base@sha256:7fa7445dfbebae4f4b7ab0e6ef99276e96075ae42584af6286ba080750d6dfe5"},
+		},
+		{
+			image: "quay.io/org/image:latest",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			ref, err := name.ParseReference(test.image, name.WeakValidation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			refs, err := MirrorReferences(ref, mirrors)
+			var actual []string
+			for _, r := range refs {
+				actual = append(actual, r.Name())
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, actual)
+		})
+	}
+}
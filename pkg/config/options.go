@@ -29,16 +29,26 @@ type CacheOptions struct {
 // KanikoOptions are options that are set by command line arguments
 type KanikoOptions struct {
 	CacheOptions
+	BaseImageLock           string
+	BaseImageLockMode       string
 	DockerfilePath          string
+	DockerfileContent       string
 	SrcContext              string
 	SnapshotMode            string
+	SnapshotWorkers         int
 	Bucket                  string
+	IgnoreFile              string
+	ImageRewriteRules       string
 	TarPath                 string
 	Target                  string
 	CacheRepo               string
 	DigestFile              string
 	ImageNameDigestFile     string
 	OCILayoutPath           string
+	LayerReport             string
+	MaxLayerSize            sizeArg
+	MaxImageSize            sizeArg
+	MaxSizeMode             string
 	Destinations            multiArg
 	BuildArgs               multiArg
 	Insecure                bool
@@ -47,16 +57,31 @@ type KanikoOptions struct {
 	SkipTLSVerifyPull       bool
 	SingleSnapshot          bool
 	Reproducible            bool
+	SourceDateEpoch         string
+	VerifyReproducible      bool
+	VerifyReproducibleImage string
 	NoPush                  bool
 	Cache                   bool
 	Cleanup                 bool
+	PushRetry               int
+	PushIgnoreFailures      bool
+	PullRetry               int
+	CacheRetry              int
+	CustomPlatform          string
 	InsecureRegistries      multiArg
 	SkipTLSVerifyRegistries multiArg
+	IgnorePaths             multiArg
+	RegistryMirrors         keyValueArg
+	RegistryCredentials     string
+	RegistryAuths           keyValueArg
+	RegistryCertificates    keyValueArg
+	RegistryClientCerts     keyValueArg
 }
 
 // WarmerOptions are options that are set by command line arguments to the cache warmer.
 type WarmerOptions struct {
 	CacheOptions
-	Images multiArg
-	Force  bool
+	Images         multiArg
+	Force          bool
+	CustomPlatform string
 }
@@ -0,0 +1,44 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		platform    string
+		expected    v1.Platform
+		shouldError bool
+	}{
+		{platform: "linux/amd64", expected: v1.Platform{OS: "linux", Architecture: "amd64"}},
+		{platform: "linux/arm64/v8", expected: v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{platform: "linux", shouldError: true},
+		{platform: "linux//v7", shouldError: true},
+		{platform: "linux/arm/v7/extra", shouldError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.platform, func(t *testing.T) {
+			actual, err := ParsePlatform(test.platform)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldError, err, test.expected, actual)
+		})
+	}
+}
@@ -29,4 +29,26 @@ type KanikoStage struct {
 	SaveStage              bool
 	MetaArgs               []instructions.ArgCommand
 	Index                  int
+	// Extensions holds what kaniko supports in the instructions of the stage
+	// beyond what the vendored buildkit parser does
+	Extensions map[instructions.Command]Extensions
+}
+
+// Extensions are the parts of an instruction which kaniko supports but the vendored buildkit parser doesn't
+type Extensions struct {
+	Heredocs []Heredoc
+	// Flags holds the values of the flags the vendored parser rejects, e.g. "0755" for COPY --chmod=0755
+	Flags map[string]string
+}
+
+// Heredoc is a here-document used by a RUN or COPY instruction, e.g.
+//
+//	RUN <<EOF
+//	echo hello
+//	EOF
+type Heredoc struct {
+	Name    string
+	Content string
+	// Expand is false if the delimiter was quoted, in which case variables aren't expanded
+	Expand bool
 }
@@ -48,12 +48,19 @@ const (
 	KanikoIntermediateStagesDir = "/kaniko/stages"
 
 	// Various snapshot modes:
-	SnapshotModeTime = "time"
-	SnapshotModeFull = "full"
+	SnapshotModeTime    = "time"
+	SnapshotModeFull    = "full"
+	SnapshotModeInotify = "inotify"
+	SnapshotModeRedo    = "redo"
 
 	// NoBaseImage is the scratch image
 	NoBaseImage = "scratch"
 
+	// WhiteoutPrefix is the prefix of the files which mark paths deleted in a layer
+	WhiteoutPrefix = ".wh."
+	// OpaqueWhiteout is the file which marks that the contents of its directory in lower layers are hidden
+	OpaqueWhiteout = ".wh..wh..opq"
+
 	GCSBuildContextPrefix      = "gs://"
 	S3BuildContextPrefix       = "s3://"
 	LocalDirBuildContextPrefix = "dir://"
@@ -75,6 +82,35 @@ const (
 	// S3 Custom endpoint ENV name
 	S3EndpointEnv    = "S3_ENDPOINT"
 	S3ForcePathStyle = "S3_FORCE_PATH_STYLE"
+
+	// DockerfileTokenEnv is the ENV name of the bearer token used to fetch a Dockerfile from a URL
+	DockerfileTokenEnv = "DOCKERFILE_TOKEN"
+
+	// Number of times fetching a Dockerfile from a URL is retried
+	DockerfileFetchRetries = 3
+
+	// RetryDelayMilliseconds is the delay before the first retry of --push-retry, --pull-retry and --cache-retry,
+	// which doubles with every further retry
+	RetryDelayMilliseconds = 1000
+
+	// PushResultsSuffix is appended to the name of the digest file to get the file recording the result of each push
+	PushResultsSuffix = ".push-results.json"
+
+	// ImageRewritesLabel is the label recording the images rewritten by --image-rewrite-rules
+	ImageRewritesLabel = "kaniko.image-rewrites"
+
+	// Modes of the --base-image-lock file
+	BaseImageLockModeStrict = "strict"
+	BaseImageLockModeWarn   = "warn"
+	BaseImageLockModeUpdate = "update"
+
+	// SourceDateEpoch is the environment variable which sets the default of --source-date-epoch,
+	// see https://reproducible-builds.org/specs/source-date-epoch/
+	SourceDateEpoch = "SOURCE_DATE_EPOCH"
+
+	// What to do when --max-layer-size or --max-image-size is exceeded
+	MaxSizeModeFail = "fail"
+	MaxSizeModeWarn = "warn"
 )
 
 // ScratchEnvVars are the default environment variables needed for a scratch image.
@@ -0,0 +1,269 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package creds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// registryCredentials is the directory of the docker config file set with --registry-credentials
+	registryCredentials string
+	// registryAuths are the credentials set with --registry-auth, keyed by registry
+	registryAuths = map[string]authn.Authenticator{}
+)
+
+// dockerConfigFile is the name of the docker config file, which authn.DefaultKeychain reads from $DOCKER_CONFIG
+const dockerConfigFile = "config.json"
+
+// SetRegistryCredentials configures the docker config file and the static user:token credentials,
+// keyed by registry, which GetKeychain uses before the default keychain. It must be called before GetKeychain.
+func SetRegistryCredentials(configFile string, auths map[string][]string) error {
+	if configFile != "" {
+		path, err := filepath.Abs(configFile)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return errors.Wrap(err, "reading registry credentials")
+		}
+		if fi.IsDir() {
+			path = filepath.Join(path, dockerConfigFile)
+			if _, err := os.Stat(path); err != nil {
+				return errors.Wrap(err, "reading registry credentials")
+			}
+		} else if filepath.Base(path) != dockerConfigFile {
+			return fmt.Errorf("registry credentials %s must be a directory or a file named %s", configFile, dockerConfigFile)
+		}
+		registryCredentials = filepath.Dir(path)
+	}
+	for registry, values := range auths {
+		reg, err := name.NewRegistry(registry, name.WeakValidation)
+		if err != nil {
+			return errors.Wrapf(err, "parsing registry %s", registry)
+		}
+		// The last value set for a registry wins.
+		parts := strings.SplitN(values[len(values)-1], ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("credentials for %s must be of the form user:token", registry)
+		}
+		registryAuths[reg.RegistryStr()] = &authn.Basic{Username: parts[0], Password: parts[1]}
+	}
+	return nil
+}
+
+// configuredKeychains returns the keychains set up with SetRegistryCredentials
+func configuredKeychains() []namedKeychain {
+	var keychains []namedKeychain
+	if len(registryAuths) > 0 {
+		keychains = append(keychains, namedKeychain{name: "--registry-auth", Keychain: staticKeychain(registryAuths)})
+	}
+	if registryCredentials != "" {
+		keychains = append(keychains, namedKeychain{
+			name:     filepath.Join(registryCredentials, dockerConfigFile),
+			Keychain: dockerConfigKeychain(registryCredentials),
+		})
+	}
+	return keychains
+}
+
+// namedKeychain is a keychain with a name describing where its credentials come from
+type namedKeychain struct {
+	authn.Keychain
+	name string
+}
+
+// sourceKeychain resolves credentials from the first of its keychains which has credentials
+// for a registry, like authn.NewMultiKeychain, and logs which one it used. Credential helpers
+// are run while resolving, so a helper without credentials falls through to the next keychain.
+type sourceKeychain struct {
+	keychains []namedKeychain
+	logged    sync.Map
+}
+
+func newSourceKeychain(keychains ...namedKeychain) *sourceKeychain {
+	return &sourceKeychain{keychains: keychains}
+}
+
+// Resolve implements authn.Keychain.
+func (s *sourceKeychain) Resolve(reg name.Registry) (authn.Authenticator, error) {
+	for _, k := range s.keychains {
+		auth, err := k.Resolve(reg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving credentials for %s from %s", reg.RegistryStr(), k.name)
+		}
+		if auth == authn.Anonymous {
+			continue
+		}
+		a, err := auth.Authorization()
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving credentials for %s from %s", reg.RegistryStr(), k.name)
+		}
+		if a == "" {
+			// e.g. a credential helper with no credentials for this registry
+			continue
+		}
+		s.logOnce(reg, fmt.Sprintf("Using credentials for %s from %s", reg.RegistryStr(), k.name))
+		return resolvedAuth(a), nil
+	}
+	s.logOnce(reg, fmt.Sprintf("No credentials found for %s, using anonymous access", reg.RegistryStr()))
+	return authn.Anonymous, nil
+}
+
+func (s *sourceKeychain) logOnce(reg name.Registry, msg string) {
+	if _, logged := s.logged.LoadOrStore(reg.RegistryStr(), true); !logged {
+		logrus.Info(msg)
+	}
+}
+
+// staticKeychain resolves the credentials set for a registry with --registry-auth
+type staticKeychain map[string]authn.Authenticator
+
+// Resolve implements authn.Keychain.
+func (s staticKeychain) Resolve(reg name.Registry) (authn.Authenticator, error) {
+	if auth, ok := s[reg.RegistryStr()]; ok {
+		return auth, nil
+	}
+	return authn.Anonymous, nil
+}
+
+// resolvedAuth is an authorization header value already resolved by a keychain
+type resolvedAuth string
+
+// Authorization implements authn.Authenticator.
+func (r resolvedAuth) Authorization() (string, error) {
+	return string(r), nil
+}
+
+// dockerConfigKeychain resolves credentials like authn.DefaultKeychain, from the
+// config.json in its directory rather than in $DOCKER_CONFIG
+type dockerConfigKeychain string
+
+// dockerConfig is the part of a docker config.json holding credentials
+type dockerConfig struct {
+	CredHelpers map[string]string     `json:"credHelpers,omitempty"`
+	CredsStore  string                `json:"credsStore,omitempty"`
+	Auths       map[string]dockerAuth `json:"auths,omitempty"`
+}
+
+// dockerAuth is an entry of the auths of a docker config.json
+type dockerAuth struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Resolve implements authn.Keychain.
+func (d dockerConfigKeychain) Resolve(reg name.Registry) (authn.Authenticator, error) {
+	path := filepath.Join(string(d), dockerConfigFile)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg dockerConfig
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+
+	// As in docker, per-registry credential helpers come first, then the credential store, then auths.
+	keys := registryKeys(reg)
+	for _, key := range keys {
+		if helper, ok := cfg.CredHelpers[key]; ok {
+			return credentialHelper{name: helper, registry: reg}, nil
+		}
+	}
+	if cfg.CredsStore != "" {
+		return credentialHelper{name: cfg.CredsStore, registry: reg}, nil
+	}
+	for _, key := range keys {
+		entry, ok := cfg.Auths[key]
+		if !ok {
+			continue
+		}
+		if entry.Auth != "" {
+			return resolvedAuth("Basic " + entry.Auth), nil
+		}
+		if entry.Username != "" {
+			return &authn.Basic{Username: entry.Username, Password: entry.Password}, nil
+		}
+		return nil, fmt.Errorf("unsupported entry for %s in %s", key, path)
+	}
+	return authn.Anonymous, nil
+}
+
+// registryKeys returns the forms a registry may be keyed by in a docker config.json
+func registryKeys(reg name.Registry) []string {
+	r := reg.Name()
+	return []string{
+		r,
+		"https://" + r,
+		"http://" + r,
+		"https://" + r + "/v1/",
+		"http://" + r + "/v1/",
+		"https://" + r + "/v2/",
+		"http://" + r + "/v2/",
+	}
+}
+
+// credentialNotFound is what a docker credential helper prints when it has no credentials for a registry
+const credentialNotFound = "credentials not found in native keychain"
+
+// credentialHelper resolves the credentials for a registry with docker-credential-<name>
+type credentialHelper struct {
+	name     string
+	registry name.Registry
+}
+
+// Authorization implements authn.Authenticator.
+func (c credentialHelper) Authorization() (string, error) {
+	helper := "docker-credential-" + c.name
+	cmd := exec.Command(helper, "get")
+	cmd.Stdin = strings.NewReader("https://" + c.registry.Name())
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	runErr := cmd.Run()
+
+	output := strings.TrimSpace(out.String())
+	if output == credentialNotFound {
+		return "", nil
+	}
+	if runErr != nil {
+		return "", errors.Wrapf(runErr, "running %s", helper)
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal([]byte(output), &creds); err != nil {
+		return "", errors.Wrapf(err, "parsing the output of %s", helper)
+	}
+	return (&authn.Basic{Username: creds.Username, Password: creds.Secret}).Authorization()
+}
@@ -30,7 +30,8 @@ var (
 // GetKeychain returns a keychain for accessing container registries.
 func GetKeychain() authn.Keychain {
 	setupKeyChainOnce.Do(func() {
-		keyChain = authn.NewMultiKeychain(authn.DefaultKeychain)
+		keychains := append(configuredKeychains(), namedKeychain{name: "the default docker config", Keychain: authn.DefaultKeychain})
+		keyChain = newSourceKeychain(keychains...)
 	})
 	return keyChain
 }
@@ -33,7 +33,8 @@ var (
 // GetKeychain returns a keychain for accessing container registries.
 func GetKeychain() authn.Keychain {
 	setupKeyChainOnce.Do(func() {
-		keyChain = authn.NewMultiKeychain(authn.DefaultKeychain)
+		keychains := append(configuredKeychains(), namedKeychain{name: "the default docker config", Keychain: authn.DefaultKeychain})
+		keyChain = newSourceKeychain(keychains...)
 
 		// Add the Kubernetes keychain if we're on Kubernetes
 		r, err := container.DetectRuntime()
@@ -47,7 +48,7 @@ func GetKeychain() authn.Keychain {
 				logrus.Warnf("Error setting up k8schain. Using default keychain %s", err)
 				return
 			}
-			keyChain = authn.NewMultiKeychain(keyChain, k8sc)
+			keyChain = newSourceKeychain(append(keychains, namedKeychain{name: "the kubernetes keychain", Keychain: k8sc})...)
 		}
 	})
 	return keyChain
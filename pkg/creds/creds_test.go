@@ -0,0 +1,187 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package creds
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func basic(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func authorization(t *testing.T, k authn.Keychain, registry string) string {
+	reg, err := name.NewRegistry(registry, name.WeakValidation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := k.Resolve(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := auth.Authorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func Test_dockerConfigKeychain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "creds-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake credential helper which returns the registry it was asked about as the secret
+	helper := "#!/bin/sh\nread registry\necho \"{\\\"Username\\\": \\\"helper\\\", \\\"Secret\\\": \\\"$registry\\\"}\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config := `{
+	"credHelpers": {"gcr.io": "fake"},
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:pass")) + `"},
+		"quay.io": {"username": "quser", "password": "qpass"}
+	}
+}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	k := dockerConfigKeychain(dir)
+
+	tests := []struct {
+		registry string
+		expected string
+	}{
+		{registry: "gcr.io", expected: basic("helper", "https://gcr.io")},
+		{registry: "docker.io", expected: basic("user", "pass")},
+		{registry: "quay.io", expected: basic("quser", "qpass")},
+		{registry: "example.com", expected: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.registry, func(t *testing.T) {
+			testutil.CheckDeepEqual(t, test.expected, authorization(t, k, test.registry))
+		})
+	}
+}
+
+func Test_sourceKeychain(t *testing.T) {
+	original := registryAuths
+	defer func() { registryAuths = original }()
+	registryAuths = map[string]authn.Authenticator{}
+
+	if err := SetRegistryCredentials("", map[string][]string{
+		"docker.io": {"old:token", "user:token"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	k := newSourceKeychain(append(configuredKeychains(), namedKeychain{
+		name:     "fallback",
+		Keychain: staticKeychain{"gcr.io": &authn.Basic{Username: "fallback", Password: "secret"}},
+	})...)
+
+	testutil.CheckDeepEqual(t, basic("user", "token"), authorization(t, k, "index.docker.io"))
+	testutil.CheckDeepEqual(t, basic("fallback", "secret"), authorization(t, k, "gcr.io"))
+	testutil.CheckDeepEqual(t, "", authorization(t, k, "quay.io"))
+}
+
+func Test_sourceKeychain_HelperNotFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "creds-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake credential helper which has no credentials for any registry
+	helper := "#!/bin/sh\necho \"credentials not found in native keychain\"\nexit 1\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker-credential-empty"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"credsStore": "empty"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	k := newSourceKeychain(namedKeychain{
+		name:     "config",
+		Keychain: dockerConfigKeychain(dir),
+	}, namedKeychain{
+		name:     "fallback",
+		Keychain: staticKeychain{"gcr.io": &authn.Basic{Username: "fallback", Password: "secret"}},
+	})
+	testutil.CheckDeepEqual(t, basic("fallback", "secret"), authorization(t, k, "gcr.io"))
+	testutil.CheckDeepEqual(t, "", authorization(t, k, "quay.io"))
+}
+
+func Test_SetRegistryCredentials_ConfigFile(t *testing.T) {
+	original := registryCredentials
+	defer func() { registryCredentials = original }()
+
+	dir, err := ioutil.TempDir("", "creds-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"config.json", "other.json"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(`{}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		description string
+		path        string
+		shouldErr   bool
+	}{
+		{description: "config file", path: filepath.Join(dir, "config.json")},
+		{description: "directory", path: dir},
+		{description: "other file name", path: filepath.Join(dir, "other.json"), shouldErr: true},
+		{description: "missing file", path: filepath.Join(dir, "missing", "config.json"), shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			registryCredentials = ""
+			err := SetRegistryCredentials(test.path, nil)
+			testutil.CheckError(t, test.shouldErr, err)
+			if !test.shouldErr {
+				testutil.CheckDeepEqual(t, dir, registryCredentials)
+			}
+		})
+	}
+}
+
+func Test_SetRegistryCredentials_Invalid(t *testing.T) {
+	original := registryAuths
+	defer func() { registryAuths = original }()
+	registryAuths = map[string]authn.Authenticator{}
+
+	err := SetRegistryCredentials("", map[string][]string{"gcr.io": {"token"}})
+	testutil.CheckError(t, true, err)
+}
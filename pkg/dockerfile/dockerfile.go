@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -28,32 +29,26 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/transport"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
+	"github.com/moby/buildkit/frontend/dockerfile/command"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
 	"github.com/pkg/errors"
 )
 
+// for testing
+var fetchRetryDelayMilliseconds = 1000
+
 // Stages parses a Dockerfile and returns an array of KanikoStage
 func Stages(opts *config.KanikoOptions) ([]config.KanikoStage, error) {
-	var err error
-	var d []uint8
-	match, _ := regexp.MatchString("^https?://", opts.DockerfilePath)
-	if match {
-		response, e := http.Get(opts.DockerfilePath)
-		if e != nil {
-			return nil, e
-		}
-		d, err = ioutil.ReadAll(response.Body)
-	} else {
-		d, err = ioutil.ReadFile(opts.DockerfilePath)
-	}
-
+	d, err := readDockerfile(opts.DockerfilePath)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("reading dockerfile at path %s", opts.DockerfilePath))
 	}
 
-	stages, metaArgs, err := Parse(d)
+	stages, metaArgs, extensions, err := Parse(d)
 	if err != nil {
 		return nil, errors.Wrap(err, "parsing dockerfile")
 	}
@@ -78,6 +73,7 @@ func Stages(opts *config.KanikoOptions) ([]config.KanikoStage, error) {
 			Final:                  index == targetStage,
 			MetaArgs:               metaArgs,
 			Index:                  index,
+			Extensions:             extensions,
 		})
 		if index == targetStage {
 			break
@@ -87,6 +83,42 @@ func Stages(opts *config.KanikoOptions) ([]config.KanikoStage, error) {
 	return kanikoStages, nil
 }
 
+// readDockerfile reads the Dockerfile from a local path, or fetches it if path is a URL
+func readDockerfile(path string) ([]byte, error) {
+	if match, _ := regexp.MatchString("^https?://", path); !match {
+		return ioutil.ReadFile(path)
+	}
+	var d []byte
+	// Only network errors and 5xx or 429 responses are retried, e.g. a 404 fails right away
+	err := util.RetryTransient(func() error {
+		var err error
+		d, err = fetchDockerfile(path)
+		return err
+	}, constants.DockerfileFetchRetries, fetchRetryDelayMilliseconds)
+	return d, err
+}
+
+// fetchDockerfile downloads the Dockerfile at url, authenticating with the token
+// in $DOCKERFILE_TOKEN if set. Basic auth credentials can be passed in the url itself.
+func fetchDockerfile(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv(constants.DockerfileTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := transport.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &util.StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
 // baseImageIndex returns the index of the stage the current stage is built off
 // returns -1 if the current stage isn't built off a previous stage
 func baseImageIndex(currentStage int, stages []instructions.Stage) int {
@@ -101,23 +133,66 @@ func baseImageIndex(currentStage int, stages []instructions.Stage) int {
 	return -1
 }
 
-// Parse parses the contents of a Dockerfile and returns a list of commands
-func Parse(b []byte) ([]instructions.Stage, []instructions.ArgCommand, error) {
+// Parse parses the contents of a Dockerfile and returns a list of commands,
+// along with the extensions of the commands which use any
+func Parse(b []byte) ([]instructions.Stage, []instructions.ArgCommand, map[instructions.Command]config.Extensions, error) {
+	b, docs, err := extractHeredocs(b)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	p, err := parser.Parse(bytes.NewReader(b))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	extras, err := stripExtraFlags(p.AST)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 	stages, metaArgs, err := instructions.Parse(p.AST)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
+	extensions := registerExtensions(p.AST, stages, docs, extras)
 
 	metaArgs, err = stripEnclosingQuotes(metaArgs)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+
+	return stages, metaArgs, extensions, nil
+}
+
+// registerExtensions associates the heredocs found by extractHeredocs and the flags removed by
+// stripExtraFlags with the parsed instructions.
+// Nodes map to instructions the same way they do in instructions.Parse.
+func registerExtensions(ast *parser.Node, stages []instructions.Stage, docs map[int][]config.Heredoc, extras map[*parser.Node]map[string]string) map[instructions.Command]config.Extensions {
+	extensions := map[instructions.Command]config.Extensions{}
+	if len(docs) == 0 && len(extras) == 0 {
+		return extensions
+	}
+	stageIndex, cmdIndex := -1, 0
+	for _, node := range ast.Children {
+		if node.Value == command.From {
+			stageIndex++
+			cmdIndex = 0
+			continue
+		}
+		if stageIndex < 0 {
+			continue
+		}
+		cmd := stages[stageIndex].Commands[cmdIndex]
+		cmdIndex++
+		registerNode(node, cmd, docs, extras, extensions)
 	}
+	return extensions
+}
 
-	return stages, metaArgs, nil
+func registerNode(node *parser.Node, cmd instructions.Command, docs map[int][]config.Heredoc, extras map[*parser.Node]map[string]string, extensions map[instructions.Command]config.Extensions) {
+	d, hasDocs := docs[node.StartLine]
+	f, hasFlags := extras[node]
+	if hasDocs || hasFlags {
+		extensions[cmd] = config.Extensions{Heredocs: d, Flags: f}
+	}
 }
 
 // stripEnclosingQuotes removes quotes enclosing the value of each instructions.ArgCommand in a slice
@@ -225,22 +300,33 @@ func resolveStages(stages []instructions.Stage) {
 	}
 }
 
-// ParseCommands parses an array of commands into an array of instructions.Command; used for onbuild
-func ParseCommands(cmdArray []string) ([]instructions.Command, error) {
+// ParseCommands parses an array of commands into an array of instructions.Command,
+// along with the extensions of the commands which use any; used for onbuild
+func ParseCommands(cmdArray []string) ([]instructions.Command, map[instructions.Command]config.Extensions, error) {
 	var cmds []instructions.Command
 	cmdString := strings.Join(cmdArray, "\n")
-	ast, err := parser.Parse(strings.NewReader(cmdString))
+	b, docs, err := extractHeredocs([]byte(cmdString))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	ast, err := parser.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	extras, err := stripExtraFlags(ast.AST)
+	if err != nil {
+		return nil, nil, err
 	}
+	extensions := map[instructions.Command]config.Extensions{}
 	for _, child := range ast.AST.Children {
 		cmd, err := instructions.ParseCommand(child)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		registerNode(child, cmd, docs, extras, extensions)
 		cmds = append(cmds, cmd)
 	}
-	return cmds, nil
+	return cmds, extensions, nil
 }
 
 // SaveStage returns true if the current stage will be needed later in the Dockerfile
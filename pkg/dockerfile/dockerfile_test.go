@@ -17,7 +17,10 @@ limitations under the License.
 package dockerfile
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
 	"testing"
@@ -75,6 +78,54 @@ func Test_Stages_ArgValueWithQuotes(t *testing.T) {
 	}
 }
 
+func Test_readDockerfile_URL(t *testing.T) {
+	original := fetchRetryDelayMilliseconds
+	defer func() { fetchRetryDelayMilliseconds = original }()
+	fetchRetryDelayMilliseconds = 0
+	dockerfile := "FROM scratch\n"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, dockerfile)
+	}))
+	defer server.Close()
+
+	os.Setenv("DOCKERFILE_TOKEN", "secret")
+	defer os.Unsetenv("DOCKERFILE_TOKEN")
+
+	d, err := readDockerfile(server.URL + "/Dockerfile")
+	testutil.CheckErrorAndDeepEqual(t, false, err, dockerfile, string(d))
+	testutil.CheckDeepEqual(t, 2, requests)
+}
+
+func Test_readDockerfile_URLNotRetried(t *testing.T) {
+	original := fetchRetryDelayMilliseconds
+	defer func() { fetchRetryDelayMilliseconds = original }()
+	fetchRetryDelayMilliseconds = 0
+
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			_, err := readDockerfile(server.URL + "/Dockerfile")
+			testutil.CheckErrorAndDeepEqual(t, true, err, 1, requests)
+		})
+	}
+}
+
 func Test_stripEnclosingQuotes(t *testing.T) {
 	type testCase struct {
 		name     string
@@ -206,7 +257,7 @@ func Test_resolveStages(t *testing.T) {
 	COPY --from=third /hi3 /hi4
 	COPY --from=2 /hi3 /hi4
 	`
-	stages, _, err := Parse([]byte(dockerfile))
+	stages, _, _, err := Parse([]byte(dockerfile))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -237,7 +288,7 @@ func Test_targetStage(t *testing.T) {
 	FROM scratch
 	COPY --from=second /hi2 /hi3
 	`
-	stages, _, err := Parse([]byte(dockerfile))
+	stages, _, _, err := Parse([]byte(dockerfile))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -316,7 +367,7 @@ func Test_SaveStage(t *testing.T) {
 			expected: false,
 		},
 	}
-	stages, _, err := Parse([]byte(testutil.Dockerfile))
+	stages, _, _, err := Parse([]byte(testutil.Dockerfile))
 	if err != nil {
 		t.Fatalf("couldn't retrieve stages from Dockerfile: %v", err)
 	}
@@ -351,7 +402,7 @@ func Test_baseImageIndex(t *testing.T) {
 		},
 	}
 
-	stages, _, err := Parse([]byte(testutil.Dockerfile))
+	stages, _, _, err := Parse([]byte(testutil.Dockerfile))
 	if err != nil {
 		t.Fatalf("couldn't retrieve stages from Dockerfile: %v", err)
 	}
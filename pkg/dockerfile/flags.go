@@ -0,0 +1,94 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+// extraFlags are the instruction flags kaniko supports which the vendored buildkit parser
+// rejects, keyed by instruction. Boolean flags may be given without a value.
+var extraFlags = map[string]map[string]bool{
+	command.Add: {
+		"checksum": false,
+	},
+	command.Copy: {
+		"chmod": false,
+		"link":  true,
+	},
+}
+
+// stripExtraFlags removes the extra flags from the nodes in ast, so the vendored parser accepts them,
+// and returns their values keyed by node
+func stripExtraFlags(ast *parser.Node) (map[*parser.Node]map[string]string, error) {
+	stripped := map[*parser.Node]map[string]string{}
+	for _, node := range ast.Children {
+		values, err := stripNodeFlags(node)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) > 0 {
+			stripped[node] = values
+		}
+	}
+	return stripped, nil
+}
+
+func stripNodeFlags(node *parser.Node) (map[string]string, error) {
+	supported, ok := extraFlags[node.Value]
+	if !ok {
+		return nil, nil
+	}
+	values := map[string]string{}
+	var remaining []string
+	for _, f := range node.Flags {
+		name, value, hasValue := splitFlag(f)
+		isBool, ok := supported[name]
+		if !ok {
+			remaining = append(remaining, f)
+			continue
+		}
+		if isBool {
+			if !hasValue {
+				value = "true"
+			}
+			if _, err := strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for --%s on line %d", value, name, node.StartLine)
+			}
+		} else if !hasValue || value == "" {
+			return nil, fmt.Errorf("missing value for --%s on line %d", name, node.StartLine)
+		}
+		values[name] = value
+	}
+	node.Flags = remaining
+	return values, nil
+}
+
+// splitFlag splits a flag such as --chmod=0755 into its name and value
+func splitFlag(f string) (string, string, bool) {
+	f = strings.TrimPrefix(f, "--")
+	i := strings.Index(f, "=")
+	if i < 0 {
+		return f, "", false
+	}
+	return f[:i], f[i+1:], true
+}
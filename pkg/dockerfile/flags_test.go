@@ -0,0 +1,72 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+)
+
+func Test_Parse_ExtraFlags(t *testing.T) {
+	dockerfile := `FROM scratch
+COPY --chmod=0755 --from=builder --link /bin/app /app
+COPY --link=false foo bar
+COPY foo bar
+`
+	stages, _, extensions, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds := stages[0].Commands
+
+	copyCmd := cmds[0].(*instructions.CopyCommand)
+	testutil.CheckDeepEqual(t, "builder", copyCmd.From)
+	chmod, ok := extensions[copyCmd].Flags["chmod"]
+	testutil.CheckDeepEqual(t, true, ok)
+	testutil.CheckDeepEqual(t, "0755", chmod)
+	link := extensions[copyCmd].Flags["link"]
+	testutil.CheckDeepEqual(t, "true", link)
+
+	link = extensions[cmds[1]].Flags["link"]
+	testutil.CheckDeepEqual(t, "false", link)
+
+	_, ok = extensions[cmds[2]].Flags["chmod"]
+	testutil.CheckDeepEqual(t, false, ok)
+}
+
+func Test_Parse_InvalidExtraFlags(t *testing.T) {
+	tests := []string{
+		"FROM scratch\nCOPY --chmod foo bar\n",
+		"FROM scratch\nCOPY --link=maybe foo bar\n",
+		"FROM scratch\nADD --chmod=0755 foo bar\n",
+	}
+	for _, test := range tests {
+		_, _, _, err := Parse([]byte(test))
+		testutil.CheckError(t, true, err)
+	}
+}
+
+func Test_ParseCommands_ExtraFlags(t *testing.T) {
+	cmds, extensions, err := ParseCommands([]string{"COPY --chmod=0600 foo bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chmod := extensions[cmds[0]].Flags["chmod"]
+	testutil.CheckDeepEqual(t, "0600", chmod)
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+var (
+	heredocMarker   = regexp.MustCompile(`^<<(-?)(["']?)([a-zA-Z_][a-zA-Z0-9_]*)(["']?)$`)
+	escapeDirective = regexp.MustCompile(`^#[ \t]*escape[ \t]*=[ \t]*(.).*$`)
+)
+
+// HeredocName returns the name of the heredoc if word is a heredoc marker such as <<EOF
+func HeredocName(word string) (string, bool) {
+	match := heredocMarker.FindStringSubmatch(word)
+	if match == nil || match[2] != match[4] {
+		return "", false
+	}
+	return match[3], true
+}
+
+// extractHeredocs removes the bodies of heredocs from the Dockerfile in b, so it can be parsed
+// by the vendored parser. The bodies are replaced with empty lines so the line numbers of the
+// remaining instructions don't change, and are returned by the line of the instruction using them.
+func extractHeredocs(b []byte) ([]byte, map[int][]config.Heredoc, error) {
+	lines := strings.Split(string(b), "\n")
+	docs := map[int][]config.Heredoc{}
+	escapeToken := string(parser.DefaultEscapeToken)
+	seenInstruction := false
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if match := escapeDirective.FindStringSubmatch(trimmed); match != nil && !seenInstruction {
+			escapeToken = match[1]
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		seenInstruction = true
+		startLine := i + 1
+		instruction := trimmed
+		for strings.HasSuffix(strings.TrimRight(lines[i], " \t"), escapeToken) && i+1 < len(lines) {
+			i++
+			instruction += "\n" + lines[i]
+		}
+		if !supportsHeredocs(instruction) {
+			continue
+		}
+		for _, word := range splitWords(instruction) {
+			marker := heredocMarker.FindStringSubmatch(word)
+			if marker == nil || marker[2] != marker[4] {
+				continue
+			}
+			chomp, name := marker[1] == "-", marker[3]
+			var body []string
+			terminated := false
+			for i+1 < len(lines) {
+				i++
+				line := lines[i]
+				lines[i] = ""
+				if chomp {
+					line = strings.TrimLeft(line, "\t")
+				}
+				if strings.TrimRight(line, "\r") == name {
+					terminated = true
+					break
+				}
+				body = append(body, line)
+			}
+			if !terminated {
+				return nil, nil, fmt.Errorf("unterminated heredoc %s on line %d", name, startLine)
+			}
+			content := ""
+			if len(body) > 0 {
+				content = strings.Join(body, "\n") + "\n"
+			}
+			docs[startLine] = append(docs[startLine], config.Heredoc{
+				Name:    name,
+				Content: content,
+				Expand:  marker[2] == "",
+			})
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), docs, nil
+}
+
+// splitWords splits s on whitespace outside of quotes, keeping the quotes in the words,
+// so that only whole words are treated as heredoc markers
+func splitWords(s string) []string {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ' ' || r == '\t' || r == '\n':
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			continue
+		}
+		word.WriteRune(r)
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+func supportsHeredocs(instruction string) bool {
+	fields := strings.Fields(instruction)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToLower(fields[0]) {
+	case command.Run, command.Copy:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+)
+
+func Test_Parse_Heredocs(t *testing.T) {
+	dockerfile := `FROM scratch
+RUN <<EOF
+echo hello
+echo world
+EOF
+COPY <<-"CONFIG" <<EOT /etc/
+	name=$NAME
+	CONFIG
+some $TEXT
+EOT
+RUN echo "a<<b" && \
+    cat <<EOF > /file
+contents
+EOF
+RUN echo done
+`
+	stages, _, extensions, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds := stages[0].Commands
+	if len(cmds) != 4 {
+		t.Fatalf("expected 4 commands, got %d", len(cmds))
+	}
+
+	testutil.CheckDeepEqual(t, []config.Heredoc{
+		{Name: "EOF", Content: "echo hello\necho world\n", Expand: true},
+	}, extensions[cmds[0]].Heredocs)
+
+	copyCmd, ok := cmds[1].(*instructions.CopyCommand)
+	if !ok {
+		t.Fatalf("expected COPY, got %T", cmds[1])
+	}
+	testutil.CheckDeepEqual(t, []string{`<<-"CONFIG"`, "<<EOT", "/etc/"}, []string(copyCmd.SourcesAndDest))
+	testutil.CheckDeepEqual(t, []config.Heredoc{
+		{Name: "CONFIG", Content: "name=$NAME\n", Expand: false},
+		{Name: "EOT", Content: "some $TEXT\n", Expand: true},
+	}, extensions[cmds[1]].Heredocs)
+
+	testutil.CheckDeepEqual(t, []config.Heredoc{
+		{Name: "EOF", Content: "contents\n", Expand: true},
+	}, extensions[cmds[2]].Heredocs)
+
+	testutil.CheckDeepEqual(t, 0, len(extensions[cmds[3]].Heredocs))
+}
+
+func Test_Parse_UnterminatedHeredoc(t *testing.T) {
+	dockerfile := `FROM scratch
+RUN <<EOF
+echo hello
+`
+	_, _, _, err := Parse([]byte(dockerfile))
+	testutil.CheckError(t, true, err)
+}
+
+func Test_HeredocName(t *testing.T) {
+	tests := []struct {
+		word     string
+		name     string
+		expected bool
+	}{
+		{word: "<<EOF", name: "EOF", expected: true},
+		{word: "<<-EOF", name: "EOF", expected: true},
+		{word: `<<"EOF"`, name: "EOF", expected: true},
+		{word: "<<'EOF'", name: "EOF", expected: true},
+		{word: `<<"EOF'`},
+		{word: "EOF"},
+		{word: "a<<EOF"},
+	}
+	for _, test := range tests {
+		t.Run(test.word, func(t *testing.T) {
+			name, ok := HeredocName(test.word)
+			testutil.CheckDeepEqual(t, test.expected, ok)
+			testutil.CheckDeepEqual(t, test.name, name)
+		})
+	}
+}
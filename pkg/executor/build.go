@@ -17,9 +17,11 @@ limitations under the License.
 package executor
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"time"
 
@@ -57,6 +59,8 @@ type snapShotter interface {
 	Init() error
 	TakeSnapshotFS() (string, error)
 	TakeSnapshot([]string) (string, error)
+	Changes() snapshot.Changes
+	Close() error
 }
 
 // stageBuilder contains all fields necessary to build one stage of a Dockerfile
@@ -75,10 +79,13 @@ type stageBuilder struct {
 	snapshotter      snapShotter
 	layerCache       cache.LayerCache
 	pushCache        cachePusher
+	layerReport      *layerReport
+	// sourceDateEpoch is the time set with --source-date-epoch, or zero if unset
+	sourceDateEpoch time.Time
 }
 
 // newStageBuilder returns a new type stageBuilder which contains all the information required to build the stage
-func newStageBuilder(opts *config.KanikoOptions, stage config.KanikoStage, crossStageDeps map[int][]string, dcm map[string]string, sid map[string]string) (*stageBuilder, error) {
+func newStageBuilder(opts *config.KanikoOptions, stage config.KanikoStage, crossStageDeps map[int][]string, dcm map[string]string, sid map[string]string, epoch time.Time) (*stageBuilder, error) {
 	sourceImage, err := util.RetrieveSourceImage(stage, opts)
 	if err != nil {
 		return nil, err
@@ -89,6 +96,15 @@ func newStageBuilder(opts *config.KanikoOptions, stage config.KanikoStage, cross
 		return nil, err
 	}
 
+	if opts.CustomPlatform != "" {
+		platform, err := config.ParsePlatform(opts.CustomPlatform)
+		if err != nil {
+			return nil, err
+		}
+		imageConfig.OS = platform.OS
+		imageConfig.Architecture = platform.Architecture
+	}
+
 	if err := resolveOnBuild(&stage, &imageConfig.Config); err != nil {
 		return nil, err
 	}
@@ -99,6 +115,21 @@ func newStageBuilder(opts *config.KanikoOptions, stage config.KanikoStage, cross
 	}
 	l := snapshot.NewLayeredMap(hasher, util.CacheHasher())
 	snapshotter := snapshot.NewSnapshotter(l, constants.RootDir)
+	workers := opts.SnapshotWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	snapshotter.SetHashWorkers(workers)
+	if !epoch.IsZero() {
+		snapshotter.ClampModTimes(epoch)
+	}
+	if opts.SnapshotMode == constants.SnapshotModeInotify {
+		tracker, err := snapshot.NewInotifyTracker()
+		if err != nil {
+			return nil, err
+		}
+		snapshotter.TrackChanges(tracker)
+	}
 
 	digest, err := sourceImage.Digest()
 	if err != nil {
@@ -117,11 +148,12 @@ func newStageBuilder(opts *config.KanikoOptions, stage config.KanikoStage, cross
 		layerCache: &cache.RegistryCache{
 			Opts: opts,
 		},
-		pushCache: pushLayerToCache,
+		pushCache:       pushLayerToCache,
+		sourceDateEpoch: epoch,
 	}
 
 	for _, cmd := range s.stage.Commands {
-		command, err := commands.GetCommand(cmd, opts.SrcContext)
+		command, err := commands.GetCommand(cmd, opts.SrcContext, s.stage.Extensions[cmd])
 		if err != nil {
 			return nil, err
 		}
@@ -148,9 +180,23 @@ func initializeConfig(img partial.WithConfigFile) (*v1.ConfigFile, error) {
 	return imageConfig, nil
 }
 
-func (s *stageBuilder) populateCompositeKey(command fmt.Stringer, files []string, compositeKey CompositeCache) (CompositeCache, error) {
+func (s *stageBuilder) populateCompositeKey(command fmt.Stringer, files []string, compositeKey CompositeCache, cfg v1.Config) (CompositeCache, error) {
 	// Add the next command to the cache key.
 	compositeKey.AddKey(command.String())
+	// Heredoc bodies aren't part of the command string, so add them explicitly.
+	if h, ok := command.(commands.HeredocCommand); ok {
+		for _, heredoc := range h.Heredocs() {
+			compositeKey.AddKey(heredoc.Content)
+		}
+	}
+	// Only look up remote sources when caching, since it requires network access.
+	if r, ok := command.(commands.RemoteSourceCommand); ok && s.opts != nil && s.opts.Cache {
+		keys, err := r.RemoteSourceKeys(&cfg, s.args)
+		if err != nil {
+			return compositeKey, errors.Wrap(err, "failed to get remote source keys")
+		}
+		compositeKey.AddKey(keys...)
+	}
 	switch v := command.(type) {
 	case *commands.CopyCommand:
 		compositeKey = s.populateCopyCmdCompositeKey(command, v.From(), compositeKey)
@@ -182,6 +228,48 @@ func (s *stageBuilder) populateCopyCmdCompositeKey(command fmt.Stringer, from st
 	return compositeKey
 }
 
+// layerCacheKey returns the key the layer created by command is cached under, given the cache key ck
+// of the image up to and including command. Linked commands (COPY --link) don't depend on the layers
+// before them, so their key only covers the command, the files it uses and the config it's resolved
+// against, which lets the layer be reused when the base image changes.
+func (s *stageBuilder) layerCacheKey(command fmt.Stringer, files []string, cfg v1.Config, ck string) (string, error) {
+	if !isLinked(command) {
+		return ck, nil
+	}
+	linkedKey := NewCompositeCache(cfg.WorkingDir)
+	linkedKey.AddKey(cfg.Env...)
+	linkedKey.AddKey(s.opts.BuildArgs...)
+	key, err := s.populateCompositeKey(command, files, *linkedKey, cfg)
+	if err != nil {
+		return "", err
+	}
+	return key.Hash()
+}
+
+func isLinked(command fmt.Stringer) bool {
+	switch v := command.(type) {
+	case *commands.CopyCommand:
+		return v.Link()
+	case *commands.CachingCopyCommand:
+		return v.Link()
+	}
+	return false
+}
+
+// recordCachedLayerOrigin records that the layer of img, retrieved from the cache with layerKey,
+// exists in the cache repo, so it can be mounted from there when pushing
+func recordCachedLayerOrigin(opts *config.KanikoOptions, layerKey string, img v1.Image) error {
+	destination, err := cache.Destination(opts, layerKey)
+	if err != nil {
+		return err
+	}
+	ref, err := name.ParseReference(destination, name.WeakValidation)
+	if err != nil {
+		return err
+	}
+	return util.RecordLayerOrigins(img, ref)
+}
+
 func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) error {
 	if !s.opts.Cache {
 		return nil
@@ -201,7 +289,7 @@ func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) erro
 			return errors.Wrap(err, "failed to get files used from context")
 		}
 
-		compositeKey, err = s.populateCompositeKey(command, files, compositeKey)
+		compositeKey, err = s.populateCompositeKey(command, files, compositeKey, cfg)
 		if err != nil {
 			return err
 		}
@@ -215,17 +303,33 @@ func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) erro
 		logrus.Debugf("optimize: cache key for command %v %v", command.String(), ck)
 		s.finalCacheKey = ck
 
-		if command.ShouldCacheOutput() && !stopCache {
-			img, err := s.layerCache.RetrieveLayer(ck)
+		linked := isLinked(command)
+		if command.ShouldCacheOutput() && (!stopCache || linked) {
+			layerKey, err := s.layerCacheKey(command, files, cfg, ck)
+			if err != nil {
+				return err
+			}
+			var img v1.Image
+			err = util.RetryTransient(func() error {
+				var err error
+				img, err = s.layerCache.RetrieveLayer(layerKey)
+				return err
+			}, s.opts.CacheRetry, constants.RetryDelayMilliseconds)
 
 			if err != nil {
 				logrus.Debugf("Failed to retrieve layer: %s", err)
 				logrus.Infof("No cached layer found for cmd %s", command.String())
 				logrus.Debugf("Key missing was: %s", compositeKey.Key())
-				stopCache = true
+				if !linked {
+					stopCache = true
+				}
 				continue
 			}
 
+			if err := recordCachedLayerOrigin(s.opts, layerKey, img); err != nil {
+				logrus.Debugf("Unable to record the origin of the cached layer: %s", err)
+			}
+
 			if cacheCmd := command.CacheCommand(img); cacheCmd != nil {
 				logrus.Infof("Using caching version of cmd: %s", command.String())
 				s.cmds[i] = cacheCmd
@@ -292,6 +396,7 @@ func (s *stageBuilder) build() error {
 	if err := s.snapshotter.Init(); err != nil {
 		return err
 	}
+	defer s.snapshotter.Close()
 
 	timing.DefaultRun.Stop(t)
 
@@ -309,7 +414,15 @@ func (s *stageBuilder) build() error {
 			return errors.Wrap(err, "failed to get files used from context")
 		}
 
-		*compositeKey, err = s.populateCompositeKey(command, files, *compositeKey)
+		*compositeKey, err = s.populateCompositeKey(command, files, *compositeKey, s.cf.Config)
+		if err != nil {
+			return err
+		}
+		ck, err := compositeKey.Hash()
+		if err != nil {
+			return errors.Wrap(err, "failed to hash composite key")
+		}
+		layerKey, err := s.layerCacheKey(command, files, s.cf.Config, ck)
 		if err != nil {
 			return err
 		}
@@ -332,17 +445,12 @@ func (s *stageBuilder) build() error {
 		}
 
 		logrus.Debugf("build: composite key for command %v %v", command.String(), compositeKey)
-		ck, err := compositeKey.Hash()
-		if err != nil {
-			return errors.Wrap(err, "failed to hash composite key")
-		}
-
-		logrus.Debugf("build: cache key for command %v %v", command.String(), ck)
+		logrus.Debugf("build: cache key for command %v %v", command.String(), layerKey)
 
 		// Push layer to cache (in parallel) now along with new config file
 		if s.opts.Cache && command.ShouldCacheOutput() {
 			cacheGroup.Go(func() error {
-				return s.pushCache(s.opts, ck, tarPath, command.String())
+				return s.pushCache(s.opts, layerKey, tarPath, command.String())
 			})
 		}
 		if err := s.saveSnapshotToImage(command.String(), tarPath); err != nil {
@@ -409,21 +517,51 @@ func (s *stageBuilder) saveSnapshotToImage(createdBy string, tarPath string) err
 		logrus.Info("No files were changed, appending empty layer to config. No layer added to image.")
 		return nil
 	}
+	// Only the layers of the final stage end up in the image
+	if s.stage.Final {
+		if err := checkLayerSize(s.opts, createdBy, fi.Size(), s.snapshotter.Changes()); err != nil {
+			return err
+		}
+	}
 
 	layer, err := tarball.LayerFromFile(tarPath)
 	if err != nil {
 		return err
 	}
+	history := v1.History{
+		Author:    constants.Author,
+		CreatedBy: createdBy,
+	}
+	if !s.sourceDateEpoch.IsZero() {
+		history.Created = v1.Time{Time: s.sourceDateEpoch}
+	}
 	s.image, err = mutate.Append(s.image,
 		mutate.Addendum{
-			Layer: layer,
-			History: v1.History{
-				Author:    constants.Author,
-				CreatedBy: createdBy,
-			},
+			Layer:   layer,
+			History: history,
 		},
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if s.layerReport != nil {
+		s.layerReport.Layers = append(s.layerReport.Layers, newLayerReportEntry(s.stage.Index, createdBy, s.snapshotter.Changes()))
+	}
+	return nil
+}
+
+// sourceDateEpoch returns the time set with --source-date-epoch, or zero if it isn't set.
+// The modification times in kaniko's layers are clamped to it, and it is the creation time of the image and its layers,
+// while the layers of the base image are left untouched.
+func sourceDateEpoch(opts *config.KanikoOptions) (time.Time, error) {
+	if opts.SourceDateEpoch == "" {
+		return time.Time{}, nil
+	}
+	epoch, err := util.ParseSourceDateEpoch(opts.SourceDateEpoch)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "invalid --source-date-epoch")
+	}
+	return epoch, nil
 }
 
 func CalculateDependencies(opts *config.KanikoOptions) (map[int][]string, error) {
@@ -493,13 +631,19 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 	t := timing.Start("Total Build Time")
 	digestToCacheKey := make(map[string]string)
 	stageIdxToDigest := make(map[string]string)
+	util.ResetImageRewrites()
+	util.ResetLayerOrigins()
+	epoch, err := sourceDateEpoch(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// Parse dockerfile
 	stages, err := dockerfile.Stages(opts)
 	if err != nil {
 		return nil, err
 	}
-	if err := util.GetExcludedFiles(opts.DockerfilePath, opts.SrcContext); err != nil {
+	if err := util.GetExcludedFiles(opts.DockerfilePath, opts.SrcContext, opts.IgnoreFile); err != nil {
 		return nil, err
 	}
 	// Some stages may refer to other random images, not previous stages
@@ -513,16 +657,33 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 	}
 	logrus.Infof("Built cross stage deps: %v", crossStageDependencies)
 
+	var report *layerReport
+	if opts.LayerReport != "" {
+		report = &layerReport{}
+		// Also written when the build fails, to help finding out why
+		defer func() {
+			if err := report.write(opts.LayerReport); err != nil {
+				logrus.Warnf("Unable to write layer report: %s", err)
+			}
+		}()
+	}
+
 	for index, stage := range stages {
-		sb, err := newStageBuilder(opts, stage, crossStageDependencies, digestToCacheKey, stageIdxToDigest)
+		sb, err := newStageBuilder(opts, stage, crossStageDependencies, digestToCacheKey, stageIdxToDigest, epoch)
 		if err != nil {
 			return nil, err
 		}
+		sb.layerReport = report
 		if err := sb.build(); err != nil {
 			return nil, errors.Wrap(err, "error building stage")
 		}
 
 		reviewConfig(stage, &sb.cf.Config)
+		if stage.Final {
+			if err := addImageRewriteLabel(&sb.cf.Config); err != nil {
+				return nil, err
+			}
+		}
 
 		sourceImage, err := mutate.Config(sb.image, sb.cf.Config)
 		if err != nil {
@@ -541,7 +702,11 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 		logrus.Debugf("mapping digest %v to cachekey %v", d.String(), sb.finalCacheKey)
 
 		if stage.Final {
-			sourceImage, err = mutate.CreatedAt(sourceImage, v1.Time{Time: time.Now()})
+			created := time.Now()
+			if !epoch.IsZero() {
+				created = epoch
+			}
+			sourceImage, err = mutate.CreatedAt(sourceImage, v1.Time{Time: created})
 			if err != nil {
 				return nil, err
 			}
@@ -551,6 +716,21 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 					return nil, err
 				}
 			}
+			if opts.CustomPlatform != "" {
+				platform, err := config.ParsePlatform(opts.CustomPlatform)
+				if err != nil {
+					return nil, err
+				}
+				if platform.Variant != "" {
+					sourceImage, err = util.ImageWithVariant(sourceImage, platform.Variant)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err := checkImageSize(opts, sourceImage); err != nil {
+				return nil, err
+			}
 			if opts.Cleanup {
 				if err = util.DeleteFilesystem(); err != nil {
 					return nil, err
@@ -599,6 +779,23 @@ func filesToSave(deps []string) ([]string, error) {
 	return allFiles, nil
 }
 
+// addImageRewriteLabel records the images rewritten by the --image-rewrite-rules in a label
+func addImageRewriteLabel(cfg *v1.Config) error {
+	rewrites := util.ImageRewrites()
+	if len(rewrites) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(rewrites)
+	if err != nil {
+		return err
+	}
+	if cfg.Labels == nil {
+		cfg.Labels = map[string]string{}
+	}
+	cfg.Labels[constants.ImageRewritesLabel] = string(b)
+	return nil
+}
+
 func fetchExtraStages(stages []config.KanikoStage, opts *config.KanikoOptions) error {
 	t := timing.Start("Fetching Extra Stages")
 	defer timing.DefaultRun.Stop(t)
@@ -627,10 +824,17 @@ func fetchExtraStages(stages []config.KanikoStage, opts *config.KanikoOptions) e
 			}
 			// This must be an image name, fetch it.
 			logrus.Debugf("Found extra base image stage %s", c.From)
-			sourceImage, err := util.RetrieveRemoteImage(c.From, opts)
+			image, err := util.ApplyImageRewriteRules(c.From, opts)
+			if err != nil {
+				return err
+			}
+			sourceImage, err := util.RetrieveLockedImage(image, opts, util.RetrieveRemoteImage)
 			if err != nil {
 				return err
 			}
+			if err := util.RecordImageRewrite(c.From, image, sourceImage); err != nil {
+				return err
+			}
 			if err := saveStageAsTarball(c.From, sourceImage); err != nil {
 				return err
 			}
@@ -680,6 +884,14 @@ func getHasher(snapshotMode string) (func(string) (string, error), error) {
 	if snapshotMode == constants.SnapshotModeFull {
 		return util.Hasher(), nil
 	}
+	if snapshotMode == constants.SnapshotModeInotify {
+		logrus.Info("Only files changed according to inotify will be hashed when snapshotting")
+		return util.Hasher(), nil
+	}
+	if snapshotMode == constants.SnapshotModeRedo {
+		logrus.Info("Only file metadata will be considered when snapshotting")
+		return util.RedoHasher(), nil
+	}
 	return nil, fmt.Errorf("%s is not a valid snapshot mode", snapshotMode)
 }
 
@@ -688,12 +900,16 @@ func resolveOnBuild(stage *config.KanikoStage, config *v1.Config) error {
 		return nil
 	}
 	// Otherwise, parse into commands
-	cmds, err := dockerfile.ParseCommands(config.OnBuild)
+	cmds, extensions, err := dockerfile.ParseCommands(config.OnBuild)
 	if err != nil {
 		return err
 	}
 	// Append to the beginning of the commands in the stage
 	stage.Commands = append(cmds, stage.Commands...)
+	for cmd, e := range stage.Extensions {
+		extensions[cmd] = e
+	}
+	stage.Extensions = extensions
 	logrus.Infof("Executing %v build triggers", len(cmds))
 
 	// Blank out the Onbuild command list for this image
@@ -26,15 +26,19 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/commands"
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/GoogleContainerTools/kaniko/testutil"
 	"github.com/google/go-cmp/cmp"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 )
 
@@ -79,7 +83,7 @@ func Test_reviewConfig(t *testing.T) {
 }
 
 func stage(t *testing.T, d string) config.KanikoStage {
-	stages, _, err := dockerfile.Parse([]byte(d))
+	stages, _, _, err := dockerfile.Parse([]byte(d))
 	if err != nil {
 		t.Fatalf("error parsing dockerfile: %v", err)
 	}
@@ -517,6 +521,65 @@ func Test_stageBuilder_optimize(t *testing.T) {
 	}
 }
 
+func Test_stageBuilder_populateCompositeKey_Heredocs(t *testing.T) {
+	hashFor := func(body string) string {
+		stages, _, extensions, err := dockerfile.Parse([]byte("FROM scratch\nRUN <<EOF\n" + body + "\nEOF\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		command, err := commands.GetCommand(stages[0].Commands[0], "", extensions[stages[0].Commands[0]])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb := &stageBuilder{}
+		ck, err := sb.populateCompositeKey(command, nil, *NewCompositeCache("base"), v1.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := ck.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	if hashFor("echo foo") == hashFor("echo bar") {
+		t.Error("expected heredoc contents to change the cache key")
+	}
+}
+
+func Test_stageBuilder_layerCacheKey_Link(t *testing.T) {
+	keyFor := func(copyCmd, base string) string {
+		stages, _, extensions, err := dockerfile.Parse([]byte("FROM scratch\n" + copyCmd + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		command, err := commands.GetCommand(stages[0].Commands[0], "", extensions[stages[0].Commands[0]])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb := &stageBuilder{opts: &config.KanikoOptions{}}
+		ck, err := sb.populateCompositeKey(command, nil, *NewCompositeCache(base), v1.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := ck.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := sb.layerCacheKey(command, nil, v1.Config{}, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	if keyFor("COPY --link foo bar", "base1") != keyFor("COPY --link foo bar", "base2") {
+		t.Error("expected the cache key of a linked COPY not to depend on the base image")
+	}
+	if keyFor("COPY foo bar", "base1") == keyFor("COPY foo bar", "base2") {
+		t.Error("expected the cache key of COPY to depend on the base image")
+	}
+}
+
 func Test_stageBuilder_build(t *testing.T) {
 	type testcase struct {
 		description       string
@@ -934,6 +997,7 @@ func getCommands(dir string, cmds []instructions.Command) []commands.DockerComma
 		cmd, err := commands.GetCommand(
 			c,
 			dir,
+			config.Extensions{},
 		)
 		if err != nil {
 			panic(err)
@@ -993,3 +1057,104 @@ func generateTar(t *testing.T, dir string, fileNames ...string) []byte {
 	}
 	return buf.Bytes()
 }
+
+func Test_stageBuilder_saveSnapshotToImage_SourceDateEpoch(t *testing.T) {
+	base := empty.Image
+	for i := 0; i < 2; i++ {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		base, err = mutate.Append(base, mutate.Addendum{
+			Layer: layers[0],
+			History: v1.History{
+				CreatedBy: fmt.Sprintf("base layer %d", i),
+				Created:   v1.Time{Time: time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := ioutil.TempFile("", "layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	tw := tar.NewWriter(f)
+	content := bytes.Repeat([]byte("a"), 2048)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: int64(len(content)), ModTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	epoch := time.Unix(1600000000, 0).UTC()
+	sb := &stageBuilder{
+		image:           base,
+		opts:            &config.KanikoOptions{},
+		sourceDateEpoch: epoch,
+	}
+	if err := sb.saveSnapshotToImage("RUN something", f.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	digests := func(img v1.Image) []v1.Hash {
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ds []v1.Hash
+		for _, l := range layers {
+			d, err := l.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ds = append(ds, d)
+		}
+		return ds
+	}
+	baseDigests := digests(base)
+	testutil.CheckDeepEqual(t, baseDigests, digests(sb.image)[:len(baseDigests)])
+
+	baseCf, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := sb.image.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, append(baseCf.History, v1.History{
+		Author:    constants.Author,
+		CreatedBy: "RUN something",
+		Created:   v1.Time{Time: epoch},
+	}), cf.History)
+}
+
+func TestDoBuild_ResetsImageRewrites(t *testing.T) {
+	// Left over from a previous build, e.g. the first one of --verify-reproducible
+	if err := util.RecordImageRewrite("gcr.io/distroless/base", "registry.internal/distroless/base", empty.Image); err != nil {
+		t.Fatal(err)
+	}
+	defer util.ResetImageRewrites()
+
+	// The build fails right after its state is reset, as there is no Dockerfile
+	_, err := DoBuild(&config.KanikoOptions{DockerfilePath: filepath.Join(os.TempDir(), "missing", "Dockerfile")})
+	testutil.CheckError(t, true, err)
+	testutil.CheckDeepEqual(t, []util.ImageRewrite{}, util.ImageRewrites())
+
+	cfg := &v1.Config{}
+	testutil.CheckError(t, false, addImageRewriteLabel(cfg))
+	testutil.CheckDeepEqual(t, map[string]string(nil), cfg.Labels)
+}
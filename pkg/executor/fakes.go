@@ -25,6 +25,7 @@ import (
 
 	"github.com/GoogleContainerTools/kaniko/pkg/commands"
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/snapshot"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
@@ -34,13 +35,17 @@ type fakeSnapShotter struct {
 	tarPath string
 }
 
-func (f fakeSnapShotter) Init() error { return nil }
+func (f fakeSnapShotter) Init() error  { return nil }
+func (f fakeSnapShotter) Close() error { return nil }
 func (f fakeSnapShotter) TakeSnapshotFS() (string, error) {
 	return f.tarPath, nil
 }
 func (f fakeSnapShotter) TakeSnapshot(_ []string) (string, error) {
 	return f.tarPath, nil
 }
+func (f fakeSnapShotter) Changes() snapshot.Changes {
+	return snapshot.Changes{}
+}
 
 type MockDockerCommand struct {
 	contextFiles []string
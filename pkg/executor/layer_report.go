@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/snapshot"
+	"github.com/pkg/errors"
+)
+
+// largestContributors is how many of the largest files of each layer are listed in the layer report
+const largestContributors = 10
+
+// layerReport describes what each layer added to the images of a build, for --layer-report
+type layerReport struct {
+	Layers []layerReportEntry `json:"layers"`
+}
+
+// layerReportEntry describes the paths added, modified and deleted by a layer
+type layerReportEntry struct {
+	Stage     int            `json:"stage"`
+	CreatedBy string         `json:"createdBy"`
+	Size      int64          `json:"size"`
+	Added     []reportedFile `json:"added"`
+	Modified  []reportedFile `json:"modified"`
+	Deleted   []string       `json:"deleted"`
+	Largest   []reportedFile `json:"largest"`
+}
+
+// reportedFile is a path in a layer, with the size of its contents if it's a regular file
+type reportedFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// newLayerReportEntry describes the layer created by createdBy from the changes of a snapshot.
+// It is called right after the snapshot, so that the sizes are those of the files in the layer.
+func newLayerReportEntry(stage int, createdBy string, changes snapshot.Changes) layerReportEntry {
+	entry := layerReportEntry{
+		Stage:     stage,
+		CreatedBy: createdBy,
+		Added:     reportedFiles(changes.Added),
+		Modified:  reportedFiles(changes.Modified),
+		Deleted:   append([]string{}, changes.Deleted...),
+	}
+	sort.Strings(entry.Deleted)
+
+	largest := append(append([]reportedFile{}, entry.Added...), entry.Modified...)
+	for _, f := range largest {
+		entry.Size += f.Size
+	}
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].Size > largest[j].Size
+	})
+	for len(largest) > 0 && largest[len(largest)-1].Size == 0 {
+		largest = largest[:len(largest)-1]
+	}
+	if len(largest) > largestContributors {
+		largest = largest[:largestContributors]
+	}
+	entry.Largest = largest
+	return entry
+}
+
+func reportedFiles(paths []string) []reportedFile {
+	files := make([]reportedFile, 0, len(paths))
+	for _, p := range paths {
+		f := reportedFile{Path: p}
+		if fi, err := os.Lstat(p); err == nil && fi.Mode().IsRegular() {
+			f.Size = fi.Size()
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// write writes the report as JSON to path
+func (r *layerReport) write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "creating layer report")
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return errors.Wrap(err, "writing layer report")
+	}
+	return nil
+}
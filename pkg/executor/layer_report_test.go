@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/snapshot"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func Test_newLayerReportEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"small":     "a",
+		"large":     strings.Repeat("a", 100),
+		"sub/empty": "",
+		"modified":  strings.Repeat("a", 10),
+	}
+	if err := testutil.SetupFiles(dir, files); err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	entry := newLayerReportEntry(1, "RUN make", snapshot.Changes{
+		Added:    []string{path("small"), path("sub"), path("large"), path("sub/empty")},
+		Modified: []string{path("modified")},
+		Deleted:  []string{path("z"), path("deleted")},
+	})
+
+	testutil.CheckDeepEqual(t, layerReportEntry{
+		Stage:     1,
+		CreatedBy: "RUN make",
+		Size:      111,
+		Added: []reportedFile{
+			{Path: path("large"), Size: 100},
+			{Path: path("small"), Size: 1},
+			{Path: path("sub")},
+			{Path: path("sub/empty")},
+		},
+		Modified: []reportedFile{{Path: path("modified"), Size: 10}},
+		Deleted:  []string{path("deleted"), path("z")},
+		Largest: []reportedFile{
+			{Path: path("large"), Size: 100},
+			{Path: path("modified"), Size: 10},
+			{Path: path("small"), Size: 1},
+		},
+	}, entry)
+
+	reportPath := path("report.json")
+	report := &layerReport{Layers: []layerReportEntry{entry}}
+	if err := report.write(reportPath); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual layerReport
+	err = json.Unmarshal(b, &actual)
+	testutil.CheckErrorAndDeepEqual(t, false, err, *report, actual)
+}
@@ -17,7 +17,6 @@ limitations under the License.
 package executor
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -25,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/cache"
@@ -32,7 +32,8 @@ import (
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/pkg/creds"
 	"github.com/GoogleContainerTools/kaniko/pkg/timing"
-	"github.com/GoogleContainerTools/kaniko/pkg/version"
+	"github.com/GoogleContainerTools/kaniko/pkg/transport"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
@@ -45,23 +46,6 @@ import (
 	"github.com/spf13/afero"
 )
 
-type withUserAgent struct {
-	t http.RoundTripper
-}
-
-const (
-	UpstreamClientUaKey = "UPSTREAM_CLIENT_TYPE"
-)
-
-func (w *withUserAgent) RoundTrip(r *http.Request) (*http.Response, error) {
-	ua := []string{fmt.Sprintf("kaniko/%s", version.Version())}
-	if upstream := os.Getenv(UpstreamClientUaKey); upstream != "" {
-		ua = append(ua, upstream)
-	}
-	r.Header.Set("User-Agent", strings.Join(ua, ","))
-	return w.t.RoundTrip(r)
-}
-
 // CheckPushPermissions checks that the configured credentials can be used to
 // push to every specified destination.
 func CheckPushPermissions(opts *config.KanikoOptions) error {
@@ -87,8 +71,13 @@ func CheckPushPermissions(opts *config.KanikoOptions) error {
 			}
 			destRef.Repository.Registry = newReg
 		}
-		tr := makeTransport(opts, registryName)
-		if err := remote.CheckPushPermission(destRef, creds.GetKeychain(), tr); err != nil {
+		tr, err := makeTransport(opts, registryName)
+		if err != nil {
+			return errors.Wrapf(err, "making transport for %s", registryName)
+		}
+		if err := util.RetryTransient(func() error {
+			return remote.CheckPushPermission(destRef, creds.GetKeychain(), tr)
+		}, opts.PushRetry, constants.RetryDelayMilliseconds); err != nil {
 			return errors.Wrapf(err, "checking push permission for %q", destRef)
 		}
 		checked[destRef.Context().RepositoryStr()] = true
@@ -168,31 +157,107 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		return nil
 	}
 
-	// continue pushing unless an error occurs
-	for _, destRef := range destRefs {
-		registryName := destRef.Repository.Registry.Name()
-		if opts.Insecure || opts.InsecureRegistries.Contains(registryName) {
-			newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
-			if err != nil {
-				return errors.Wrap(err, "getting new insecure registry")
+	// Push to all destinations in parallel, and wait for all of them to finish even if one fails
+	results := make([]pushResult, len(destRefs))
+	var wg sync.WaitGroup
+	for i, destRef := range destRefs {
+		wg.Add(1)
+		go func(i int, destRef name.Tag) {
+			defer wg.Done()
+			results[i] = pushResult{Destination: destRef.String(), Success: true}
+			if err := pushToDestination(image, destRef, opts); err != nil {
+				results[i].Success = false
+				results[i].Error = err.Error()
 			}
-			destRef.Repository.Registry = newReg
+		}(i, destRef)
+	}
+	wg.Wait()
+	timing.DefaultRun.Stop(t)
+
+	if err := writePushResults(image, results, opts); err != nil {
+		logrus.Warnf("Unable to write push results: %s", err)
+	}
+
+	var pushed []name.Tag
+	var failures []string
+	for i, result := range results {
+		if result.Success {
+			logrus.Infof("Pushed image to %s", result.Destination)
+			pushed = append(pushed, destRefs[i])
+			continue
+		}
+		failures = append(failures, result.Error)
+	}
+	if len(failures) > 0 {
+		if !opts.PushIgnoreFailures || len(pushed) == 0 {
+			return errors.New(strings.Join(failures, "; "))
 		}
+		for _, failure := range failures {
+			logrus.Warnf("Ignoring push failure: %s", failure)
+		}
+	}
+	return writeImageOutputs(image, pushed)
+}
 
-		pushAuth, err := creds.GetKeychain().Resolve(destRef.Context().Registry)
+// pushToDestination pushes image to destRef
+func pushToDestination(image v1.Image, destRef name.Tag, opts *config.KanikoOptions) error {
+	registryName := destRef.Repository.Registry.Name()
+	if opts.Insecure || opts.InsecureRegistries.Contains(registryName) {
+		newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
 		if err != nil {
-			return errors.Wrap(err, "resolving pushAuth")
+			return errors.Wrap(err, "getting new insecure registry")
 		}
+		destRef.Repository.Registry = newReg
+	}
 
-		tr := makeTransport(opts, registryName)
-		rt := &withUserAgent{t: tr}
+	pushAuth, err := creds.GetKeychain().Resolve(destRef.Context().Registry)
+	if err != nil {
+		return errors.Wrap(err, "resolving pushAuth")
+	}
 
-		if err := remote.Write(destRef, image, remote.WithAuth(pushAuth), remote.WithTransport(rt)); err != nil {
-			return errors.Wrap(err, fmt.Sprintf("failed to push to destination %s", destRef))
-		}
+	tr, err := makeTransport(opts, registryName)
+	if err != nil {
+		return errors.Wrapf(err, "making transport for %s", registryName)
 	}
-	timing.DefaultRun.Stop(t)
-	return writeImageOutputs(image, destRefs)
+
+	if err := util.RetryTransient(func() error {
+		return remote.Write(destRef, util.MountableImage(image, destRef), remote.WithAuth(pushAuth), remote.WithTransport(tr))
+	}, opts.PushRetry, constants.RetryDelayMilliseconds); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to push to destination %s", destRef))
+	}
+	return nil
+}
+
+// pushResult is the outcome of pushing the image to a destination
+type pushResult struct {
+	Destination string `json:"destination"`
+	Digest      string `json:"digest"`
+	Success     bool   `json:"success"`
+	Error       string `json:"error,omitempty"`
+}
+
+// writePushResults writes the result of pushing to each destination as JSON next to the digest file,
+// or the image name with digest file, if either is set
+func writePushResults(image v1.Image, results []pushResult, opts *config.KanikoOptions) error {
+	digestFile := opts.DigestFile
+	if digestFile == "" {
+		digestFile = opts.ImageNameDigestFile
+	}
+	if digestFile == "" {
+		return nil
+	}
+	d, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		results[i].Digest = d.String()
+	}
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(fs, digestFile+constants.PushResultsSuffix, append(b, '\n'), 0644)
 }
 
 var fs = afero.NewOsFs()
@@ -228,15 +293,8 @@ func writeImageOutputs(image v1.Image, destRefs []name.Tag) error {
 	return nil
 }
 
-func makeTransport(opts *config.KanikoOptions, registryName string) http.RoundTripper {
-	// Create a transport to set our user-agent.
-	tr := http.DefaultTransport
-	if opts.SkipTLSVerify || opts.SkipTLSVerifyRegistries.Contains(registryName) {
-		tr.(*http.Transport).TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
-	}
-	return tr
+func makeTransport(opts *config.KanikoOptions, registryName string) (http.RoundTripper, error) {
+	return transport.New(opts, registryName, opts.SkipTLSVerify || opts.SkipTLSVerifyRegistries.Contains(registryName))
 }
 
 // pushLayerToCache pushes layer (tagged with cacheKey) to opts.Cache
@@ -273,6 +331,9 @@ func pushLayerToCache(opts *config.KanikoOptions, cacheKey string, tarPath strin
 	cacheOpts.TarPath = ""   // tarPath doesn't make sense for Docker layers
 	cacheOpts.NoPush = false // we want to push cached layers
 	cacheOpts.Destinations = []string{cache}
+	cacheOpts.DigestFile = ""          // the digest files are for the built image
+	cacheOpts.ImageNameDigestFile = "" // and not for cached layers
+	cacheOpts.PushRetry = opts.CacheRetry
 	cacheOpts.InsecureRegistries = opts.InsecureRegistries
 	cacheOpts.SkipTLSVerifyRegistries = opts.SkipTLSVerifyRegistries
 	return DoPush(empty, &cacheOpts)
@@ -17,15 +17,18 @@ limitations under the License.
 package executor
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/testutil"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
@@ -103,50 +106,6 @@ func TestWriteImageOutputs(t *testing.T) {
 	}
 }
 
-func TestHeaderAdded(t *testing.T) {
-	tests := []struct {
-		name     string
-		upstream string
-		expected string
-	}{{
-		name:     "upstream env variable set",
-		upstream: "skaffold-v0.25.45",
-		expected: "kaniko/unset,skaffold-v0.25.45",
-	}, {
-		name:     "upstream env variable not set",
-		expected: "kaniko/unset",
-	},
-	}
-	for _, test := range tests {
-
-		t.Run(test.name, func(t *testing.T) {
-			rt := &withUserAgent{t: &mockRoundTripper{}}
-			if test.upstream != "" {
-				os.Setenv("UPSTREAM_CLIENT_TYPE", test.upstream)
-				defer func() { os.Unsetenv("UPSTREAM_CLIENT_TYPE") }()
-			}
-			req, err := http.NewRequest("GET", "dummy", nil)
-			if err != nil {
-				t.Fatalf("culd not create a req due to %s", err)
-			}
-			resp, err := rt.RoundTrip(req)
-			testutil.CheckError(t, false, err)
-			defer resp.Body.Close()
-			body, err := ioutil.ReadAll(resp.Body)
-			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, string(body))
-		})
-	}
-
-}
-
-type mockRoundTripper struct {
-}
-
-func (m *mockRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
-	ua := r.UserAgent()
-	return &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(ua))}, nil
-}
-
 func TestOCILayoutPath(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -223,3 +182,129 @@ func TestImageNameDigestFile(t *testing.T) {
 	testutil.CheckErrorAndDeepEqual(t, false, err, want, got)
 
 }
+
+func TestDoPush_Failures(t *testing.T) {
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create image: %s", err)
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatalf("could not get image digest: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, ignoreFailures := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ignore failures %v", ignoreFailures), func(t *testing.T) {
+			fs = afero.NewMemMapFs()
+			// Nothing listens on port 1, so both pushes fail.
+			opts := config.KanikoOptions{
+				Destinations:       []string{"localhost:1/foo:latest", "localhost:1/bar:latest"},
+				DigestFile:         filepath.Join(dir, "digest"),
+				InsecureRegistries: []string{"localhost:1"},
+				PushIgnoreFailures: ignoreFailures,
+			}
+			err := DoPush(image, &opts)
+			testutil.CheckError(t, true, err)
+
+			b, err := afero.ReadFile(fs, opts.DigestFile+constants.PushResultsSuffix)
+			if err != nil {
+				t.Fatalf("reading push results: %s", err)
+			}
+			var results []pushResult
+			if err := json.Unmarshal(b, &results); err != nil {
+				t.Fatalf("parsing push results: %s", err)
+			}
+			testutil.CheckDeepEqual(t, 2, len(results))
+			for i, destination := range opts.Destinations {
+				testutil.CheckDeepEqual(t, destination, results[i].Destination)
+				testutil.CheckDeepEqual(t, digest.String(), results[i].Digest)
+				testutil.CheckDeepEqual(t, false, results[i].Success)
+				testutil.CheckDeepEqual(t, true, results[i].Error != "")
+			}
+		})
+	}
+}
+
+func TestDoPush_PartialFailure(t *testing.T) {
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create image: %s", err)
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatalf("could not get image digest: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A registry which already has all the blobs and accepts any manifest
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead && strings.Contains(r.URL.Path, "/blobs/"):
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/"):
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+
+	defer os.Unsetenv("BUILDER_OUTPUT")
+	os.Setenv("BUILDER_OUTPUT", dir)
+
+	for _, ignoreFailures := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ignore failures %v", ignoreFailures), func(t *testing.T) {
+			fs = afero.NewMemMapFs()
+			// Nothing listens on port 1, so only the push to the fake registry succeeds.
+			opts := config.KanikoOptions{
+				Destinations:       []string{host + "/foo:latest", "localhost:1/bar:latest"},
+				DigestFile:         filepath.Join(dir, "digest"),
+				InsecureRegistries: []string{host, "localhost:1"},
+				PushIgnoreFailures: ignoreFailures,
+			}
+			err := DoPush(image, &opts)
+			testutil.CheckError(t, !ignoreFailures, err)
+
+			b, err := afero.ReadFile(fs, opts.DigestFile+constants.PushResultsSuffix)
+			if err != nil {
+				t.Fatalf("reading push results: %s", err)
+			}
+			var results []pushResult
+			if err := json.Unmarshal(b, &results); err != nil {
+				t.Fatalf("parsing push results: %s", err)
+			}
+			testutil.CheckDeepEqual(t, []pushResult{{
+				Destination: opts.Destinations[0],
+				Digest:      digest.String(),
+				Success:     true,
+			}, {
+				Destination: opts.Destinations[1],
+				Digest:      digest.String(),
+				Success:     false,
+				Error:       results[1].Error,
+			}}, results)
+			testutil.CheckDeepEqual(t, true, results[1].Error != "")
+
+			// Only the destination which was pushed to is written to the outputs
+			images, err := afero.ReadFile(fs, filepath.Join(dir, "images"))
+			if ignoreFailures {
+				testutil.CheckErrorAndDeepEqual(t, false, err,
+					fmt.Sprintf("{\"name\":\"%s/foo:latest\",\"digest\":\"%s\"}\n", host, digest), string(images))
+			} else {
+				testutil.CheckError(t, true, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/snapshot"
+	units "github.com/docker/go-units"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// checkLayerSize checks the size of the snapshot of a layer against --max-layer-size,
+// listing the largest files of the snapshot if it is exceeded
+func checkLayerSize(opts *config.KanikoOptions, createdBy string, size int64, changes snapshot.Changes) error {
+	if opts.MaxLayerSize <= 0 || size <= int64(opts.MaxLayerSize) {
+		return nil
+	}
+	var largest []string
+	for _, f := range newLayerReportEntry(0, createdBy, changes).Largest {
+		largest = append(largest, fmt.Sprintf("%s (%s)", f.Path, units.BytesSize(float64(f.Size))))
+	}
+	return sizeExceeded(opts, fmt.Sprintf("the layer created by %q is %s, more than --max-layer-size %s. Its largest files are: %s",
+		createdBy, units.BytesSize(float64(size)), opts.MaxLayerSize.String(), strings.Join(largest, ", ")))
+}
+
+// checkImageSize checks the size of the compressed layers of image against --max-image-size,
+// listing its largest layers if it is exceeded
+func checkImageSize(opts *config.KanikoOptions, image v1.Image) error {
+	if opts.MaxImageSize <= 0 {
+		return nil
+	}
+	m, err := image.Manifest()
+	if err != nil {
+		return err
+	}
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	// The history of the layers, if it describes all of them
+	var history []v1.History
+	for _, h := range cf.History {
+		if !h.EmptyLayer {
+			history = append(history, h)
+		}
+	}
+	if len(history) != len(m.Layers) {
+		history = nil
+	}
+
+	type layerSize struct {
+		index int
+		size  int64
+	}
+	var size int64
+	var layers []layerSize
+	for i, l := range m.Layers {
+		size += l.Size
+		layers = append(layers, layerSize{index: i, size: l.Size})
+	}
+	if size <= int64(opts.MaxImageSize) {
+		return nil
+	}
+	sort.SliceStable(layers, func(i, j int) bool {
+		return layers[i].size > layers[j].size
+	})
+	if len(layers) > largestContributors {
+		layers = layers[:largestContributors]
+	}
+	var largest []string
+	for _, l := range layers {
+		description := fmt.Sprintf("layer %d", l.index)
+		if history != nil {
+			description += fmt.Sprintf(" created by %q", history[l.index].CreatedBy)
+		}
+		largest = append(largest, fmt.Sprintf("%s (%s)", description, units.BytesSize(float64(l.size))))
+	}
+	return sizeExceeded(opts, fmt.Sprintf("the image is %s, more than --max-image-size %s. Its largest layers are: %s",
+		units.BytesSize(float64(size)), opts.MaxImageSize.String(), strings.Join(largest, ", ")))
+}
+
+// sizeExceeded returns an error with msg, or only logs it with --max-size-mode=warn
+func sizeExceeded(opts *config.KanikoOptions, msg string) error {
+	if opts.MaxSizeMode == constants.MaxSizeModeWarn {
+		logrus.Warn(msg)
+		return nil
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/snapshot"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func Test_checkLayerSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := testutil.SetupFiles(dir, map[string]string{"big": strings.Repeat("a", 2048)}); err != nil {
+		t.Fatal(err)
+	}
+	changes := snapshot.Changes{Added: []string{filepath.Join(dir, "big")}}
+
+	tests := []struct {
+		description string
+		opts        *config.KanikoOptions
+		size        int64
+		shouldErr   bool
+	}{
+		{
+			description: "unlimited",
+			opts:        &config.KanikoOptions{MaxSizeMode: constants.MaxSizeModeFail},
+			size:        4096,
+		},
+		{
+			description: "under the limit",
+			opts:        &config.KanikoOptions{MaxLayerSize: 4096, MaxSizeMode: constants.MaxSizeModeFail},
+			size:        4096,
+		},
+		{
+			description: "over the limit",
+			opts:        &config.KanikoOptions{MaxLayerSize: 1024, MaxSizeMode: constants.MaxSizeModeFail},
+			size:        4096,
+			shouldErr:   true,
+		},
+		{
+			description: "over the limit with warnings",
+			opts:        &config.KanikoOptions{MaxLayerSize: 1024, MaxSizeMode: constants.MaxSizeModeWarn},
+			size:        4096,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			err := checkLayerSize(test.opts, "RUN make", test.size, changes)
+			testutil.CheckError(t, test.shouldErr, err)
+			if err != nil && !strings.Contains(err.Error(), filepath.Join(dir, "big")+" (2KiB)") {
+				t.Errorf("Expected the largest file in %q", err)
+			}
+		})
+	}
+}
+
+func Test_checkImageSize(t *testing.T) {
+	image, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{MaxSizeMode: constants.MaxSizeModeFail}
+	testutil.CheckError(t, false, checkImageSize(opts, image))
+
+	opts.MaxImageSize = 1
+	err = checkImageSize(opts, image)
+	testutil.CheckError(t, true, err)
+	if err != nil && !strings.Contains(err.Error(), "layer 2") {
+		t.Errorf("Expected the largest layers in %q", err)
+	}
+
+	opts.MaxSizeMode = constants.MaxSizeModeWarn
+	testutil.CheckError(t, false, checkImageSize(opts, image))
+}
@@ -0,0 +1,418 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// maxReportedFileDifferences is the number of differing files reported for each layer
+const maxReportedFileDifferences = 100
+
+var (
+	// rebuild and deleteFilesystem are replaced in tests, which can't build in or delete the root directory
+	rebuild          = DoBuild
+	deleteFilesystem = util.DeleteFilesystem
+	// verifyDir is where the built image is copied to while it is built again, for testing
+	verifyDir = constants.KanikoDir
+)
+
+// VerifyReproducible builds the image a second time, or retrieves the image
+// given by --verify-reproducible-image, and checks it is the same as image,
+// reporting the differing layers and files if it isn't. It returns the image
+// to push, which is a copy of image if it was built again.
+func VerifyReproducible(image v1.Image, opts *config.KanikoOptions) (v1.Image, error) {
+	t := timing.Start("Verifying Reproducibility")
+	defer timing.DefaultRun.Stop(t)
+	image, reference, err := referenceImage(image, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting the image to compare to")
+	}
+	differences, err := compareImages(image, reference)
+	if err != nil {
+		return nil, errors.Wrap(err, "error comparing images")
+	}
+	if len(differences) == 0 {
+		d, err := image.Digest()
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("The image is reproducible, both builds have digest %s", d)
+		return image, nil
+	}
+	for _, d := range differences {
+		logrus.Warn(d)
+	}
+	return nil, fmt.Errorf("the image is not reproducible, found %d differences", len(differences))
+}
+
+// referenceImage returns the built image and the image to compare it to
+func referenceImage(image v1.Image, opts *config.KanikoOptions) (v1.Image, v1.Image, error) {
+	if opts.VerifyReproducibleImage != "" {
+		reference, err := util.RetrieveRemoteImage(opts.VerifyReproducibleImage, opts)
+		return image, reference, err
+	}
+	if !opts.Reproducible && opts.SourceDateEpoch == "" {
+		logrus.Warn("Timestamps are not stripped from the image without --reproducible or --source-date-epoch, the builds will likely differ")
+	}
+	// The final stage may be built FROM an earlier stage, whose layers are read
+	// from the intermediate stage tarballs which the second build overwrites
+	image, err := copyImage(image)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error copying the built image")
+	}
+	logrus.Info("Building the image a second time to verify it is reproducible")
+	// The second build starts again from an empty root
+	if err := deleteFilesystem(); err != nil {
+		return nil, nil, err
+	}
+	rebuildOpts := *opts
+	// Layers retrieved from the cache would trivially be the same
+	rebuildOpts.Cache = false
+	rebuildOpts.LayerReport = ""
+	reference, err := rebuild(&rebuildOpts)
+	return image, reference, err
+}
+
+// copyImage writes image to an OCI layout in verifyDir, which isn't deleted with the filesystem,
+// and returns the image read back from it
+func copyImage(image v1.Image) (v1.Image, error) {
+	dir, err := ioutil.TempDir(verifyDir, "verify")
+	if err != nil {
+		return nil, err
+	}
+	path, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		return nil, err
+	}
+	if err := path.AppendImage(image); err != nil {
+		return nil, err
+	}
+	d, err := image.Digest()
+	if err != nil {
+		return nil, err
+	}
+	return path.Image(d)
+}
+
+// compareImages returns a description of each difference between the config and layers of a and b
+func compareImages(a, b v1.Image) ([]string, error) {
+	da, err := a.Digest()
+	if err != nil {
+		return nil, err
+	}
+	db, err := b.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if da == db {
+		return nil, nil
+	}
+
+	var differences []string
+	configDifferences, err := compareConfigs(a, b)
+	if err != nil {
+		return nil, err
+	}
+	differences = append(differences, configDifferences...)
+
+	la, err := a.Layers()
+	if err != nil {
+		return nil, err
+	}
+	lb, err := b.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(la) != len(lb) {
+		differences = append(differences, fmt.Sprintf("the images have %d and %d layers", len(la), len(lb)))
+	}
+	createdBy, err := layerHistory(a)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(la) && i < len(lb); i++ {
+		description := fmt.Sprintf("layer %d", i)
+		if createdBy != nil && createdBy[i] != "" {
+			description += fmt.Sprintf(" created by %q", createdBy[i])
+		}
+		layerDifferences, err := compareLayers(la[i], lb[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "error comparing %s", description)
+		}
+		for _, d := range layerDifferences {
+			differences = append(differences, fmt.Sprintf("%s: %s", description, d))
+		}
+	}
+	if len(differences) == 0 {
+		differences = append(differences, fmt.Sprintf("the images have digests %s and %s", da, db))
+	}
+	return differences, nil
+}
+
+// compareConfigs returns the fields of the config files of a and b that differ,
+// except for the layer digests which are compared with the layers
+func compareConfigs(a, b v1.Image) ([]string, error) {
+	fa, err := flatConfig(a)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := flatConfig(b)
+	if err != nil {
+		return nil, err
+	}
+	var differences []string
+	for k, va := range fa {
+		if vb, ok := fb[k]; !ok {
+			differences = append(differences, fmt.Sprintf("config %s: %s != <unset>", k, va))
+		} else if va != vb {
+			differences = append(differences, fmt.Sprintf("config %s: %s != %s", k, va, vb))
+		}
+	}
+	for k, vb := range fb {
+		if _, ok := fa[k]; !ok {
+			differences = append(differences, fmt.Sprintf("config %s: <unset> != %s", k, vb))
+		}
+	}
+	sort.Strings(differences)
+	return differences, nil
+}
+
+// flatConfig returns the JSON values of the config file of image by their path, like config.Env[0]
+func flatConfig(image v1.Image) (map[string]string, error) {
+	b, err := image.RawConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	var cf map[string]interface{}
+	if err := json.Unmarshal(b, &cf); err != nil {
+		return nil, err
+	}
+	delete(cf, "rootfs")
+	flat := map[string]string{}
+	var flatten func(prefix string, v interface{})
+	flatten = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				if prefix == "" {
+					flatten(k, e)
+				} else {
+					flatten(prefix+"."+k, e)
+				}
+			}
+		case []interface{}:
+			for i, e := range v {
+				flatten(fmt.Sprintf("%s[%d]", prefix, i), e)
+			}
+		default:
+			b, _ := json.Marshal(v)
+			flat[prefix] = string(b)
+		}
+	}
+	flatten("", cf)
+	return flat, nil
+}
+
+// layerHistory returns the command that created each layer of image, or nil
+// if the history doesn't describe all of them
+func layerHistory(image v1.Image) ([]string, error) {
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	var createdBy []string
+	for _, h := range cf.History {
+		if !h.EmptyLayer {
+			createdBy = append(createdBy, h.CreatedBy)
+		}
+	}
+	if len(createdBy) != len(cf.RootFS.DiffIDs) {
+		return nil, nil
+	}
+	return createdBy, nil
+}
+
+// compareLayers returns the files of layers a and b that differ
+func compareLayers(a, b v1.Layer) ([]string, error) {
+	da, err := a.DiffID()
+	if err != nil {
+		return nil, err
+	}
+	db, err := b.DiffID()
+	if err != nil {
+		return nil, err
+	}
+	if da == db {
+		ca, err := a.Digest()
+		if err != nil {
+			return nil, err
+		}
+		cb, err := b.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if ca != cb {
+			return []string{fmt.Sprintf("same contents, but compressed differently to %s and %s", ca, cb)}, nil
+		}
+		return nil, nil
+	}
+
+	fa, err := layerFiles(a)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := layerFiles(b)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for p := range fa {
+		paths = append(paths, p)
+	}
+	for p := range fb {
+		if _, ok := fa[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var differences []string
+	for _, p := range paths {
+		ea, inA := fa[p]
+		eb, inB := fb[p]
+		switch {
+		case !inB:
+			differences = append(differences, fmt.Sprintf("%s only in the first image", p))
+		case !inA:
+			differences = append(differences, fmt.Sprintf("%s only in the second image", p))
+		default:
+			if attrs := ea.diff(eb); len(attrs) > 0 {
+				differences = append(differences, fmt.Sprintf("%s differs: %s", p, strings.Join(attrs, ", ")))
+			}
+		}
+	}
+	// Entries can also differ in their order only
+	if len(differences) == 0 {
+		differences = append(differences, fmt.Sprintf("same files, but different tarballs with diff ids %s and %s", da, db))
+	}
+	if len(differences) > maxReportedFileDifferences {
+		more := len(differences) - maxReportedFileDifferences
+		differences = append(differences[:maxReportedFileDifferences], fmt.Sprintf("and %d more differing files", more))
+	}
+	return differences, nil
+}
+
+// layerEntry holds the attributes of a file in a layer that make up its digest
+type layerEntry struct {
+	typeflag   byte
+	mode       int64
+	uid, gid   int
+	uname      string
+	gname      string
+	size       int64
+	modTime    string
+	linkname   string
+	devmajor   int64
+	devminor   int64
+	paxRecords map[string]string
+	digest     string
+}
+
+// diff returns the attributes of e and o that differ
+func (e layerEntry) diff(o layerEntry) []string {
+	var attrs []string
+	add := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			attrs = append(attrs, fmt.Sprintf("%s %v != %v", name, a, b))
+		}
+	}
+	add("type", string(e.typeflag), string(o.typeflag))
+	add("mode", fmt.Sprintf("%o", e.mode), fmt.Sprintf("%o", o.mode))
+	add("uid", e.uid, o.uid)
+	add("gid", e.gid, o.gid)
+	add("uname", e.uname, o.uname)
+	add("gname", e.gname, o.gname)
+	add("size", e.size, o.size)
+	add("mtime", e.modTime, o.modTime)
+	add("linkname", e.linkname, o.linkname)
+	add("devmajor", e.devmajor, o.devmajor)
+	add("devminor", e.devminor, o.devminor)
+	add("pax records", e.paxRecords, o.paxRecords)
+	add("content", e.digest, o.digest)
+	return attrs
+}
+
+// layerFiles returns the attributes of the files in layer by their path
+func layerFiles(layer v1.Layer) (map[string]layerEntry, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	files := map[string]layerEntry{}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, err
+		}
+		files[filepath.Clean("/"+hdr.Name)] = layerEntry{
+			typeflag:   hdr.Typeflag,
+			mode:       hdr.Mode,
+			uid:        hdr.Uid,
+			gid:        hdr.Gid,
+			uname:      hdr.Uname,
+			gname:      hdr.Gname,
+			size:       hdr.Size,
+			modTime:    hdr.ModTime.UTC().String(),
+			linkname:   hdr.Linkname,
+			devmajor:   hdr.Devmajor,
+			devminor:   hdr.Devminor,
+			paxRecords: hdr.PAXRecords,
+			digest:     hex.EncodeToString(h.Sum(nil)),
+		}
+	}
+	return files, nil
+}
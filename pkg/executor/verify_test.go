@@ -0,0 +1,327 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+type testFile struct {
+	name    string
+	mode    int64
+	content string
+}
+
+func testImage(t *testing.T, env []string, layers ...[]testFile) v1.Image {
+	var ls []v1.Layer
+	for _, files := range layers {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, f := range files {
+			hdr := &tar.Header{
+				Name:    f.name,
+				Mode:    f.mode,
+				Size:    int64(len(f.content)),
+				ModTime: time.Unix(0, 0),
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(f.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		l, err := tarball.LayerFromReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ls = append(ls, l)
+	}
+	image, err := mutate.AppendLayers(empty.Image, ls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err = mutate.Config(image, v1.Config{Env: env})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+func Test_compareImages(t *testing.T) {
+	base := []testFile{{name: "bin/sh", mode: 0755, content: "sh"}}
+	built := []testFile{
+		{name: "app/a", mode: 0644, content: "a"},
+		{name: "app/b", mode: 0644, content: "b"},
+		{name: "app/c", mode: 0644, content: "c"},
+	}
+	tests := []struct {
+		name     string
+		a        v1.Image
+		b        v1.Image
+		expected []string
+	}{
+		{
+			name: "same image",
+			a:    testImage(t, []string{"A=1"}, base, built),
+			b:    testImage(t, []string{"A=1"}, base, built),
+		},
+		{
+			name: "different files",
+			a:    testImage(t, []string{"A=1"}, base, built),
+			b: testImage(t, []string{"A=1"}, base, []testFile{
+				{name: "app/a", mode: 0600, content: "a"},
+				{name: "app/b", mode: 0644, content: "B"},
+				{name: "app/d", mode: 0644, content: "d"},
+			}),
+			expected: []string{
+				"layer 1: /app/a differs: mode 644 != 600",
+				"layer 1: /app/b differs: content 3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d != df7e70e5021544f4834bbee64a9e3789febc4be81470df629cad6ddb03320a5c",
+				"layer 1: /app/c only in the first image",
+				"layer 1: /app/d only in the second image",
+			},
+		},
+		{
+			name: "different config and layers",
+			a:    testImage(t, []string{"A=1"}, base, built),
+			b:    testImage(t, []string{"A=2", "B=1"}, base),
+			expected: []string{
+				`config config.Env[0]: "A=1" != "A=2"`,
+				`config config.Env[1]: <unset> != "B=1"`,
+				`config container_config.Env[0]: "A=1" != "A=2"`,
+				`config container_config.Env[1]: <unset> != "B=1"`,
+				`config history[1].created: "0001-01-01T00:00:00Z" != <unset>`,
+				"the images have 2 and 1 layers",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			differences, err := compareImages(test.a, test.b)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, differences)
+		})
+	}
+}
+
+// stubReferenceImage makes referenceImage return image, recording how it was retrieved, until the returned func is called
+func stubReferenceImage(image v1.Image, retrieved *[]string, rebuiltWith **config.KanikoOptions, deletions *int) func() {
+	originalRetrieve, originalRebuild, originalDelete := util.RetrieveRemoteImage, rebuild, deleteFilesystem
+	util.RetrieveRemoteImage = func(name string, _ *config.KanikoOptions) (v1.Image, error) {
+		*retrieved = append(*retrieved, name)
+		return image, nil
+	}
+	rebuild = func(opts *config.KanikoOptions) (v1.Image, error) {
+		*rebuiltWith = opts
+		return image, nil
+	}
+	deleteFilesystem = func() error {
+		*deletions++
+		return nil
+	}
+	return func() {
+		util.RetrieveRemoteImage, rebuild, deleteFilesystem = originalRetrieve, originalRebuild, originalDelete
+	}
+}
+
+func Test_referenceImage(t *testing.T) {
+	image := testImage(t, []string{"A=1"}, []testFile{{name: "app/a", mode: 0644, content: "a"}})
+
+	t.Run("verify reproducible image", func(t *testing.T) {
+		var retrieved []string
+		var rebuiltWith *config.KanikoOptions
+		var deletions int
+		defer stubReferenceImage(image, &retrieved, &rebuiltWith, &deletions)()
+
+		built, reference, err := referenceImage(image, &config.KanikoOptions{
+			VerifyReproducible:      true,
+			VerifyReproducibleImage: "gcr.io/project/app:v1",
+		})
+		testutil.CheckError(t, false, err)
+		if built != image {
+			t.Errorf("expected the built image not to be copied, got %v", built)
+		}
+		if reference != image {
+			t.Errorf("expected the retrieved image, got %v", reference)
+		}
+		testutil.CheckDeepEqual(t, []string{"gcr.io/project/app:v1"}, retrieved)
+		testutil.CheckDeepEqual(t, 0, deletions)
+		if rebuiltWith != nil {
+			t.Error("expected the image not to be rebuilt")
+		}
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		var retrieved []string
+		var rebuiltWith *config.KanikoOptions
+		var deletions int
+		defer stubReferenceImage(image, &retrieved, &rebuiltWith, &deletions)()
+		defer stubVerifyDir(t)()
+
+		opts := &config.KanikoOptions{
+			VerifyReproducible: true,
+			Reproducible:       true,
+			Cache:              true,
+			LayerReport:        "report.json",
+			CacheOptions:       config.CacheOptions{CacheDir: "/cache"},
+		}
+		built, reference, err := referenceImage(image, opts)
+		testutil.CheckError(t, false, err)
+		if reference != image {
+			t.Errorf("expected the rebuilt image, got %v", reference)
+		}
+		// The built image is copied, with the same digest
+		if built == image {
+			t.Error("expected the built image to be copied")
+		}
+		expected, err := image.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := built.Digest()
+		testutil.CheckErrorAndDeepEqual(t, false, err, expected, d)
+		testutil.CheckDeepEqual(t, 0, len(retrieved))
+		// The filesystem is deleted before building again
+		testutil.CheckDeepEqual(t, 1, deletions)
+		if rebuiltWith == nil || rebuiltWith == opts {
+			t.Fatal("expected the image to be rebuilt with a copy of the options")
+		}
+		// without the cache or the layer report, leaving the options of the first build as they were
+		testutil.CheckDeepEqual(t, false, rebuiltWith.Cache)
+		testutil.CheckDeepEqual(t, "", rebuiltWith.LayerReport)
+		testutil.CheckDeepEqual(t, true, rebuiltWith.Reproducible)
+		testutil.CheckDeepEqual(t, "/cache", rebuiltWith.CacheDir)
+		testutil.CheckDeepEqual(t, true, opts.Cache)
+		testutil.CheckDeepEqual(t, "report.json", opts.LayerReport)
+	})
+}
+
+func Test_VerifyReproducible(t *testing.T) {
+	base := []testFile{{name: "bin/sh", mode: 0755, content: "sh"}}
+	image := testImage(t, []string{"A=1"}, base)
+	tests := []struct {
+		name      string
+		reference v1.Image
+		shouldErr bool
+	}{
+		{name: "same image", reference: testImage(t, []string{"A=1"}, base)},
+		{name: "different image", reference: testImage(t, []string{"A=2"}, base), shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var retrieved []string
+			var rebuiltWith *config.KanikoOptions
+			var deletions int
+			defer stubReferenceImage(test.reference, &retrieved, &rebuiltWith, &deletions)()
+
+			pushed, err := VerifyReproducible(image, &config.KanikoOptions{
+				VerifyReproducible:      true,
+				VerifyReproducibleImage: "gcr.io/project/app:v1",
+			})
+			testutil.CheckError(t, test.shouldErr, err)
+			if !test.shouldErr && pushed != image {
+				t.Errorf("expected the built image to be pushed, got %v", pushed)
+			}
+		})
+	}
+}
+
+// stubVerifyDir makes the built image be copied to a temporary directory, until the returned func is called
+func stubVerifyDir(t *testing.T) func() {
+	original := verifyDir
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyDir = dir
+	return func() {
+		verifyDir = original
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_referenceImage_MultiStage(t *testing.T) {
+	defer stubVerifyDir(t)()
+	stagesDir, err := ioutil.TempDir("", "stages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stagesDir)
+	stage := filepath.Join(stagesDir, "0")
+	tag, err := name.NewTag("temp/tag", name.WeakValidation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// buildFromStage saves the stage built from files, like saveStageAsTarball, and
+	// returns a final stage FROM it, whose base layers are read from the tarball
+	buildFromStage := func(files []testFile) v1.Image {
+		if err := tarball.WriteToFile(stage, tag, testImage(t, nil, files)); err != nil {
+			t.Fatal(err)
+		}
+		base, err := tarball.ImageFromPath(stage, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		final := testImage(t, nil, []testFile{{name: "app/main", mode: 0755, content: "main"}})
+		layers, err := final.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		image, err := mutate.AppendLayers(base, layers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return image
+	}
+
+	image := buildFromStage([]testFile{{name: "app/lib", mode: 0644, content: "first"}})
+	originalRebuild, originalDelete := rebuild, deleteFilesystem
+	defer func() { rebuild, deleteFilesystem = originalRebuild, originalDelete }()
+	deleteFilesystem = func() error { return nil }
+	// The second build overwrites the stage with a different file
+	rebuild = func(*config.KanikoOptions) (v1.Image, error) {
+		return buildFromStage([]testFile{{name: "app/lib", mode: 0644, content: "second"}}), nil
+	}
+
+	built, reference, err := referenceImage(image, &config.KanikoOptions{VerifyReproducible: true, Reproducible: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The layers of the first build are still compared, rather than those of the second build
+	differences, err := compareImages(built, reference)
+	testutil.CheckErrorAndDeepEqual(t, false, err, []string{
+		"layer 0: /app/lib differs: size 5 != 6, content a7937b64b8caa58f03721bb6bacf5c78cb235febe0e70b1b84cd99541461a08e != 16367aacb67a4a017c8da8ab95682ccb390863780f7114dda0a0e0c55644c7c4",
+	}, differences)
+}
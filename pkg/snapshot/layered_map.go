@@ -22,7 +22,9 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/pkg/timing"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 )
@@ -33,6 +35,8 @@ type LayeredMap struct {
 	hasher    func(string) (string, error)
 	// cacheHasher doesn't include mtime in it's hash so that filesystem cache keys are stable
 	cacheHasher func(string) (string, error)
+	// hashes are the hashes of the changed files found by CheckFileChanges, reused by Add
+	hashes map[string]string
 }
 
 func NewLayeredMap(h func(string) (string, error), c func(string) (string, error)) *LayeredMap {
@@ -41,12 +45,14 @@ func NewLayeredMap(h func(string) (string, error), c func(string) (string, error
 		cacheHasher: c,
 	}
 	l.layers = []map[string]string{}
+	l.hashes = map[string]string{}
 	return &l
 }
 
 func (l *LayeredMap) Snapshot() {
 	l.whiteouts = append(l.whiteouts, map[string]string{})
 	l.layers = append(l.layers, map[string]string{})
+	l.hashes = map[string]string{}
 }
 
 // Key returns a hash for added files
@@ -59,10 +65,40 @@ func (l *LayeredMap) Key() (string, error) {
 
 // GetFlattenedPathsForWhiteOut returns all paths in the current FS
 func (l *LayeredMap) getFlattenedPathsForWhiteOut() map[string]struct{} {
+	return l.getFlattenedPaths(nil)
+}
+
+// getFlattenedPathsUnder returns the paths in the current FS under roots, including the roots,
+// and the ones next to the roots, which are needed to tell whether deleting a root empties its directory
+func (l *LayeredMap) getFlattenedPathsUnder(roots map[string]bool) map[string]struct{} {
+	parents := map[string]bool{}
+	for root := range roots {
+		parents[filepath.Dir(root)] = true
+	}
+	return l.getFlattenedPaths(func(p string) bool {
+		if parents[filepath.Dir(p)] {
+			return true
+		}
+		for dir := p; ; dir = filepath.Dir(dir) {
+			if roots[dir] {
+				return true
+			}
+			if dir == filepath.Dir(dir) {
+				return false
+			}
+		}
+	})
+}
+
+// getFlattenedPaths returns the paths in the current FS for which include returns true, or all of them if it is nil
+func (l *LayeredMap) getFlattenedPaths(include func(string) bool) map[string]struct{} {
 	paths := map[string]struct{}{}
 	for _, l := range l.layers {
 		for p := range l {
-			if strings.HasPrefix(filepath.Base(p), ".wh.") {
+			if include != nil && !include(p) {
+				continue
+			}
+			if strings.HasPrefix(filepath.Base(p), constants.WhiteoutPrefix) {
 				delete(paths, p)
 			}
 			paths[p] = struct{}{}
@@ -89,22 +125,45 @@ func (l *LayeredMap) GetWhiteout(s string) (string, bool) {
 	return "", false
 }
 
+// MaybeAddWhiteout records that s was deleted in the current layer,
+// and returns false if it already was deleted since it was last added.
 func (l *LayeredMap) MaybeAddWhiteout(s string) bool {
-	whiteout, ok := l.GetWhiteout(s)
-	if ok && whiteout == s {
+	if l.isWhitedOut(s) {
 		return false
 	}
 	l.whiteouts[len(l.whiteouts)-1][s] = s
 	return true
 }
 
+// isWhitedOut returns true if s, or a directory it is in, was deleted since s was last added
+func (l *LayeredMap) isWhitedOut(s string) bool {
+	for i := len(l.layers) - 1; i >= 0; i-- {
+		for p := s; ; p = filepath.Dir(p) {
+			if _, ok := l.whiteouts[i][p]; ok {
+				return true
+			}
+			if p == filepath.Dir(p) {
+				break
+			}
+		}
+		if _, ok := l.layers[i][s]; ok {
+			return false
+		}
+	}
+	return false
+}
+
 // Add will add the specified file s to the layered map.
 func (l *LayeredMap) Add(s string) error {
 	// Use hash function and add to layers
-	newV, err := l.hasher(s)
-	if err != nil {
-		return fmt.Errorf("error creating hash for %s: %v", s, err)
+	newV, ok := l.hashes[s]
+	if !ok {
+		var err error
+		if newV, err = l.hasher(s); err != nil {
+			return fmt.Errorf("error creating hash for %s: %v", s, err)
+		}
 	}
+	delete(l.hashes, s)
 	l.layers[len(l.layers)-1][s] = newV
 	return nil
 }
@@ -113,15 +172,73 @@ func (l *LayeredMap) Add(s string) error {
 // from the current layered map by its hashing function.
 // Returns true if the file is changed.
 func (l *LayeredMap) CheckFileChange(s string) (bool, error) {
+	changed, _, err := l.checkFileChange(s)
+	return changed, err
+}
+
+// checkFileChange is CheckFileChange, which also returns the new hash of the file
+func (l *LayeredMap) checkFileChange(s string) (bool, string, error) {
 	oldV, ok := l.Get(s)
 	t := timing.Start("Hashing files")
 	defer timing.DefaultRun.Stop(t)
 	newV, err := l.hasher(s)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 	if ok && newV == oldV {
-		return false, nil
+		return false, newV, nil
+	}
+	return true, newV, nil
+}
+
+// CheckFileChanges checks which of paths changed from the current layered map, like CheckFileChange,
+// hashing up to workers files concurrently. The hashes of the changed files are reused when they are added.
+func (l *LayeredMap) CheckFileChanges(paths []string, workers int) ([]string, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	type result struct {
+		path    string
+		hash    string
+		changed bool
+		err     error
+	}
+	jobs := make(chan string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				changed, hash, err := l.checkFileChange(path)
+				results <- result{path: path, hash: hash, changed: changed, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, path := range paths {
+			jobs <- path
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Only this goroutine writes to the layered map while the workers read from it.
+	var changed []string
+	var err error
+	for r := range results {
+		if r.err != nil {
+			if err == nil {
+				err = fmt.Errorf("could not check if file has changed %s %s", r.path, r.err)
+			}
+			continue
+		}
+		if r.changed {
+			changed = append(changed, r.path)
+			l.hashes[r.path] = r.hash
+		}
 	}
-	return true, nil
+	return changed, err
 }
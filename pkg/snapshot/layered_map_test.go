@@ -16,7 +16,12 @@ limitations under the License.
 package snapshot
 
 import (
+	"errors"
+	"sort"
+	"sync/atomic"
 	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
 )
 
 func Test_CacheKey(t *testing.T) {
@@ -77,3 +82,89 @@ func Test_CacheKey(t *testing.T) {
 		})
 	}
 }
+
+func Test_CheckFileChanges(t *testing.T) {
+	hashes := map[string]string{
+		"/unchanged": "1",
+		"/changed":   "3",
+		"/new":       "4",
+	}
+	for _, workers := range []int{0, 1, 4} {
+		var calls int32
+		hasher := func(p string) (string, error) {
+			atomic.AddInt32(&calls, 1)
+			if h, ok := hashes[p]; ok {
+				return h, nil
+			}
+			return "", errors.New("no such file")
+		}
+		l := NewLayeredMap(hasher, hasher)
+		l.Snapshot()
+		l.layers[0]["/unchanged"] = "1"
+		l.layers[0]["/changed"] = "2"
+		l.Snapshot()
+
+		changed, err := l.CheckFileChanges([]string{"/unchanged", "/changed", "/new"}, workers)
+		sort.Strings(changed)
+		testutil.CheckErrorAndDeepEqual(t, false, err, []string{"/changed", "/new"}, changed)
+
+		// The hashes of the changed files are reused when adding them.
+		for _, path := range changed {
+			if err := l.Add(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		testutil.CheckDeepEqual(t, int32(3), calls)
+		testutil.CheckDeepEqual(t, map[string]string{"/changed": "3", "/new": "4"}, l.layers[1])
+
+		_, err = l.CheckFileChanges([]string{"/missing", "/new"}, workers)
+		testutil.CheckError(t, true, err)
+	}
+}
+
+func Test_getFlattenedPathsUnder(t *testing.T) {
+	lm := LayeredMap{layers: []map[string]string{{
+		"/a":       "",
+		"/a/b":     "",
+		"/a/b/c":   "",
+		"/a/d":     "",
+		"/e":       "",
+		"/e/f":     "",
+		"/e/f/g":   "",
+		"/ab/file": "",
+	}, {
+		"/a/b/h": "",
+		"/e/i":   "",
+	}}}
+	tests := []struct {
+		name     string
+		roots    map[string]bool
+		expected []string
+	}{
+		{
+			name:     "directory",
+			roots:    map[string]bool{"/a/b": true},
+			expected: []string{"/a/b", "/a/b/c", "/a/b/h", "/a/d"},
+		},
+		{
+			name:     "file",
+			roots:    map[string]bool{"/e/f/g": true},
+			expected: []string{"/e/f/g"},
+		},
+		{
+			name:     "several roots",
+			roots:    map[string]bool{"/a/b/c": true, "/e/f": true},
+			expected: []string{"/a/b/c", "/a/b/h", "/e/f", "/e/f/g", "/e/i"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var actual []string
+			for p := range lm.getFlattenedPathsUnder(test.roots) {
+				actual = append(actual, p)
+			}
+			sort.Strings(actual)
+			testutil.CheckDeepEqual(t, test.expected, actual)
+		})
+	}
+}
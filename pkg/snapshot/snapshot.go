@@ -19,9 +19,11 @@ package snapshot
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"sort"
 	"syscall"
+	"time"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/timing"
 
@@ -40,17 +42,67 @@ var snapshotPathPrefix = constants.KanikoDir
 type Snapshotter struct {
 	l         *LayeredMap
 	directory string
+	tracker   ChangeTracker
+	tracking  bool
+	workers   int
+	changes   Changes
+	// maxModTime clamps the modification times in snapshots, if set
+	maxModTime time.Time
+}
+
+// Changes are the paths added, modified and deleted in a snapshot
+type Changes struct {
+	Added    []string
+	Modified []string
+	Deleted  []string
 }
 
 // NewSnapshotter creates a new snapshotter rooted at d
 func NewSnapshotter(l *LayeredMap, d string) *Snapshotter {
-	return &Snapshotter{l: l, directory: d}
+	return &Snapshotter{l: l, directory: d, workers: 1}
+}
+
+// TrackChanges makes snapshots of the full filesystem only hash the files which t records as changed
+func (s *Snapshotter) TrackChanges(t ChangeTracker) {
+	s.tracker = t
+}
+
+// SetHashWorkers sets the number of files hashed concurrently when snapshotting the full filesystem
+func (s *Snapshotter) SetHashWorkers(workers int) {
+	s.workers = workers
+}
+
+// ClampModTimes makes the modification times of the files in snapshots be max at most
+func (s *Snapshotter) ClampModTimes(max time.Time) {
+	s.maxModTime = max
 }
 
 // Init initializes a new snapshotter
 func (s *Snapshotter) Init() error {
-	_, _, err := s.scanFullFilesystem()
-	return err
+	if _, _, _, err := s.scanFullFilesystem(); err != nil {
+		return err
+	}
+	if s.tracker != nil {
+		if err := s.tracker.Start(s.directory); err != nil {
+			logrus.Warnf("Unable to track changes, checking every file when snapshotting: %s", err)
+			return nil
+		}
+		s.tracking = true
+	}
+	return nil
+}
+
+// Close stops tracking changes
+func (s *Snapshotter) Close() error {
+	if s.tracker == nil {
+		return nil
+	}
+	return s.tracker.Close()
+}
+
+// Changes returns the paths added, modified and deleted in the last snapshot
+func (s *Snapshotter) Changes() Changes {
+	return s.changes
 }
 
 // Key returns a string based on the current state of the file system
@@ -68,6 +120,7 @@ func (s *Snapshotter) TakeSnapshot(files []string) (string, error) {
 	defer f.Close()
 
 	s.l.Snapshot()
+	s.changes = Changes{}
 	if len(files) == 0 {
 		logrus.Info("No files changed in this command, skipping snapshotting.")
 		return "", nil
@@ -75,19 +128,60 @@ func (s *Snapshotter) TakeSnapshot(files []string) (string, error) {
 	logrus.Info("Taking snapshot of files...")
 	logrus.Debugf("Taking snapshot of files %v", files)
 
+	// Paths under the files, e.g. in a directory replaced by a file or another directory, may have been deleted
+	snapshotted := map[string]bool{}
+	for _, file := range files {
+		snapshotted[filepath.Clean(file)] = true
+	}
+	filesToWhiteOut, opaqueDirs := s.deletions(s.l.getFlattenedPathsUnder(snapshotted), func(path string) (bool, bool) {
+		for dir := path; ; dir = filepath.Dir(dir) {
+			if snapshotted[dir] {
+				break
+			}
+			if dir == filepath.Dir(dir) {
+				// Not under the files, so assumed to be unchanged. Only directories are asked about
+				// once the files in them are known to be deleted.
+				return true, true
+			}
+		}
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return false, false
+		}
+		return true, fi.IsDir()
+	})
+	// Whatever is in an opaque directory needs to be in this layer
+	for _, dir := range opaqueDirs {
+		if err := godirwalk.Walk(dir, &godirwalk.Options{
+			Callback: func(path string, ent *godirwalk.Dirent) error {
+				if util.CheckWhitelist(path) {
+					if util.IsDestDir(path) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				files = append(files, path)
+				return nil
+			},
+			Unsorted: true,
+		}); err != nil {
+			return "", err
+		}
+	}
+
 	// Also add parent directories to keep the permission of them correctly.
 	filesToAdd := filesWithParentDirs(files)
+	sort.Strings(filesToAdd)
 
 	// Add files to the layered map
-	for _, file := range filesToAdd {
-		if err := s.l.Add(file); err != nil {
-			return "", fmt.Errorf("unable to add file %s to layered map: %s", file, err)
-		}
+	if err := s.add(filesToAdd); err != nil {
+		return "", err
 	}
 
 	t := util.NewTar(f)
 	defer t.Close()
-	if err := writeToTar(t, filesToAdd, nil); err != nil {
+	t.ClampModTimes(s.maxModTime)
+	if err := writeToTar(t, filesToAdd, filesToWhiteOut, opaqueDirs); err != nil {
 		return "", err
 	}
 	return f.Name(), nil
@@ -103,20 +197,21 @@ func (s *Snapshotter) TakeSnapshotFS() (string, error) {
 	defer f.Close()
 	t := util.NewTar(f)
 	defer t.Close()
+	t.ClampModTimes(s.maxModTime)
 
-	filesToAdd, filesToWhiteOut, err := s.scanFullFilesystem()
+	filesToAdd, filesToWhiteOut, opaqueDirs, err := s.scanFullFilesystem()
 	if err != nil {
 		return "", err
 	}
 
-	if err := writeToTar(t, filesToAdd, filesToWhiteOut); err != nil {
+	if err := writeToTar(t, filesToAdd, filesToWhiteOut, opaqueDirs); err != nil {
 		return "", err
 	}
 
 	return f.Name(), nil
 }
 
-func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
+func (s *Snapshotter) scanFullFilesystem() ([]string, []string, []string, error) {
 	logrus.Info("Taking snapshot of full filesystem...")
 
 	// Some of the operations that follow (e.g. hashing) depend on the file system being synced,
@@ -126,6 +221,17 @@ func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
 	syscall.Sync()
 
 	s.l.Snapshot()
+	s.changes = Changes{}
+
+	// Only check the files recorded as changed, and the ones new to the layered map, if changes are tracked
+	var changed map[string]bool
+	if s.tracking {
+		var complete bool
+		if changed, complete = s.tracker.Changes(); !complete {
+			logrus.Info("Changes may have been missed, checking every file")
+			changed = nil
+		}
+	}
 
 	timer := timing.Start("Walking filesystem")
 	// Save the fs state in a map to iterate over later.
@@ -148,39 +254,47 @@ func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
 	timing.DefaultRun.Stop(timer)
 
 	// First handle whiteouts
-	//   Get a list of all the files that existed before this layer
-	existingPaths := s.l.getFlattenedPathsForWhiteOut()
-	//   Find the delta by removing everything left in this layer.
-	for p := range memFs {
-		delete(existingPaths, p)
-	}
-	//   The paths left here are the ones that have been deleted in this layer.
-	filesToWhiteOut := []string{}
-	for path := range existingPaths {
-		// Only add the whiteout if the directory for the file still exists.
-		dir := filepath.Dir(path)
-		if _, ok := memFs[dir]; ok {
-			if s.l.MaybeAddWhiteout(path) {
-				logrus.Infof("Adding whiteout for %s", path)
-				filesToWhiteOut = append(filesToWhiteOut, path)
-			}
-		}
-	}
+	filesToWhiteOut, opaqueDirs := s.deletions(s.l.getFlattenedPathsForWhiteOut(), func(path string) (bool, bool) {
+		ent, ok := memFs[path]
+		return ok, ok && ent.IsDir()
+	})
 
-	filesToAdd := []string{}
+	candidates := []string{}
 	for path := range memFs {
 		if util.CheckWhitelist(path) {
 			logrus.Tracef("Not adding %s to layer, as it's whitelisted", path)
 			continue
 		}
-		// Only add changed files.
-		fileChanged, err := s.l.CheckFileChange(path)
-		if err != nil {
-			return nil, nil, fmt.Errorf("could not check if file has changed %s %s", path, err)
+		if changed != nil && !changed[path] {
+			if _, ok := s.l.Get(path); ok {
+				continue
+			}
 		}
-		if fileChanged {
-			logrus.Tracef("Adding %s to layer, because it was changed.", path)
-			filesToAdd = append(filesToAdd, path)
+		candidates = append(candidates, path)
+	}
+
+	// Only add changed files.
+	timer = timing.Start("Hashing files (wall time)")
+	start := time.Now()
+	filesToAdd, err := s.l.CheckFileChanges(candidates, s.workers)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	timing.DefaultRun.Stop(timer)
+	if elapsed := time.Since(start); elapsed > 0 {
+		logrus.Infof("Checked %d files for changes with %d workers in %s (%.0f files/s)",
+			len(candidates), s.workers, elapsed, float64(len(candidates))/elapsed.Seconds())
+	}
+	for _, path := range filesToAdd {
+		logrus.Tracef("Adding %s to layer, because it was changed.", path)
+	}
+
+	// Whatever is in an opaque directory needs to be in this layer, even if it didn't change
+	for _, dir := range opaqueDirs {
+		for path := range memFs {
+			if util.HasFilepathPrefix(path, dir, false) && path != dir && !util.CheckWhitelist(path) {
+				filesToAdd = append(filesToAdd, path)
+			}
 		}
 	}
 
@@ -190,19 +304,104 @@ func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
 	sort.Strings(filesToAdd)
 
 	// Add files to the layered map
-	for _, file := range filesToAdd {
+	if err := s.add(filesToAdd); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return filesToAdd, filesToWhiteOut, opaqueDirs, nil
+}
+
+// add adds files to the layered map, recording whether they were added or modified. Files whose hash
+// didn't change, such as the parent directories added to keep their permissions, aren't recorded.
+func (s *Snapshotter) add(files []string) error {
+	for _, file := range files {
+		oldHash, existed := s.l.Get(file)
+		existed = existed && !s.l.isWhitedOut(file)
 		if err := s.l.Add(file); err != nil {
-			return nil, nil, fmt.Errorf("unable to add file %s to layered map: %s", file, err)
+			return fmt.Errorf("unable to add file %s to layered map: %s", file, err)
+		}
+		if !existed {
+			s.changes.Added = append(s.changes.Added, file)
+		} else if newHash, _ := s.l.Get(file); newHash != oldHash {
+			s.changes.Modified = append(s.changes.Modified, file)
 		}
 	}
+	return nil
+}
 
-	return filesToAdd, filesToWhiteOut, nil
+// deletions returns the paths of existingPaths, from the layered map, which were deleted since the last snapshot,
+// according to stat, which returns whether a path exists and is a directory. Directories whose contents were all
+// deleted, e.g. because they were deleted and created again, are returned as opaque rather than whiting out each path.
+func (s *Snapshotter) deletions(existingPaths map[string]struct{}, stat func(string) (bool, bool)) ([]string, []string) {
+	// Skip the paths deleted in an earlier layer already
+	paths := make([]string, 0, len(existingPaths))
+	for path := range existingPaths {
+		if !s.l.isWhitedOut(path) {
+			paths = append(paths, path)
+		}
+	}
+	// Group the deleted paths by their directory
+	deleted := map[string][]string{}
+	for _, path := range paths {
+		if exists, _ := stat(path); exists {
+			continue
+		}
+		dir := filepath.Dir(path)
+		deleted[dir] = append(deleted[dir], path)
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+	// and count what was in those directories
+	children := map[string]int{}
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		if _, ok := deleted[dir]; ok && dir != path {
+			children[dir]++
+		}
+	}
+
+	filesToWhiteOut := []string{}
+	opaqueDirs := []string{}
+	for dir, paths := range deleted {
+		// Only add the whiteout if the directory for the file still exists.
+		// Otherwise the whiteout of the directory, or the file replacing it, hides the file.
+		if exists, isDir := stat(dir); !exists || !isDir {
+			continue
+		}
+		// Whitelisted paths aren't in the layered map but may be in lower layers, so they mustn't be hidden.
+		opaque := len(paths) == children[dir] && dir != s.directory &&
+			!util.CheckWhitelist(dir) && !util.ChildDirInWhitelist(dir)
+		if opaque {
+			logrus.Infof("Adding opaque whiteout for %s", dir)
+			opaqueDirs = append(opaqueDirs, dir)
+		}
+		for _, path := range paths {
+			if !s.l.MaybeAddWhiteout(path) {
+				continue
+			}
+			s.changes.Deleted = append(s.changes.Deleted, path)
+			if !opaque {
+				logrus.Infof("Adding whiteout for %s", path)
+				filesToWhiteOut = append(filesToWhiteOut, path)
+			}
+		}
+	}
+	sort.Strings(s.changes.Deleted)
+	sort.Strings(filesToWhiteOut)
+	sort.Strings(opaqueDirs)
+	return filesToWhiteOut, opaqueDirs
 }
 
-func writeToTar(t util.Tar, files, whiteouts []string) error {
+func writeToTar(t util.Tar, files, whiteouts, opaqueDirs []string) error {
 	timer := timing.Start("Writing tar file")
 	defer timing.DefaultRun.Stop(timer)
 	// Now create the tar.
+	for _, dir := range opaqueDirs {
+		if err := t.OpaqueWhiteout(dir); err != nil {
+			return err
+		}
+	}
 	for _, path := range whiteouts {
 		if err := t.Whiteout(path); err != nil {
 			return err
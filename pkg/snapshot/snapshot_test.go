@@ -253,7 +253,240 @@ func TestEmptySnapshotFS(t *testing.T) {
 	}
 }
 
+func TestSnapshotFSWhiteouts(t *testing.T) {
+	tests := []struct {
+		description string
+		change      func(testDir string) error
+		expected    []string
+		unexpected  []string
+	}{
+		{
+			description: "deleted file",
+			change: func(testDir string) error {
+				return os.Remove(filepath.Join(testDir, "foo"))
+			},
+			expected: []string{".wh.foo"},
+		},
+		{
+			description: "directory deleted and created again",
+			change: func(testDir string) error {
+				if err := os.RemoveAll(filepath.Join(testDir, "bar")); err != nil {
+					return err
+				}
+				return testutil.SetupFiles(testDir, map[string]string{"bar/new": "new"})
+			},
+			expected:   []string{"bar/.wh..wh..opq", "bar", "bar/new"},
+			unexpected: []string{"bar/.wh.bat"},
+		},
+		{
+			description: "directory replaced by a file",
+			change: func(testDir string) error {
+				if err := os.RemoveAll(filepath.Join(testDir, "bar")); err != nil {
+					return err
+				}
+				return testutil.SetupFiles(testDir, map[string]string{"bar": "file"})
+			},
+			expected:   []string{"bar"},
+			unexpected: []string{"bar/.wh.bat", "bar/.wh..wh..opq", ".wh.bar"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			testDir, snapshotter, cleanup, err := setUpTestDir()
+			defer cleanup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := test.change(testDir); err != nil {
+				t.Fatal(err)
+			}
+			tarPath, err := snapshotter.TakeSnapshotFS()
+			if err != nil {
+				t.Fatalf("Error taking snapshot of fs: %s", err)
+			}
+			checkTarContents(t, testDir, tarPath, test.expected, test.unexpected)
+		})
+	}
+}
+
+func TestSnapshotFilesWhiteouts(t *testing.T) {
+	testDir, snapshotter, cleanup, err := setUpTestDir()
+	defer cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replace the contents of bar, like extracting a file over a directory does
+	if err := os.Remove(filepath.Join(testDir, "bar/bat")); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.SetupFiles(testDir, map[string]string{"bar/bat/file": "file"}); err != nil {
+		t.Fatal(err)
+	}
+	tarPath, err := snapshotter.TakeSnapshot([]string{filepath.Join(testDir, "bar/bat/file")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tarPath)
+	// The file isn't whited out, since it was replaced by a directory
+	checkTarContents(t, testDir, tarPath, []string{"bar/bat", "bar/bat/file"}, []string{"bar/.wh.bat"})
+
+	if err := os.RemoveAll(filepath.Join(testDir, "bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.SetupFiles(testDir, map[string]string{"bar/new": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	tarPath, err = snapshotter.TakeSnapshot([]string{filepath.Join(testDir, "bar")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tarPath)
+	checkTarContents(t, testDir, tarPath, []string{"bar/.wh..wh..opq", "bar", "bar/new"}, []string{"bar/.wh.bat"})
+}
+
+func TestSnapshotChanges(t *testing.T) {
+	testDir, snapshotter, cleanup, err := setUpTestDir()
+	defer cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.SetupFiles(testDir, map[string]string{"foo": "newbaz1", "bar/new": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(testDir, "bar/bat")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshotter.TakeSnapshotFS(); err != nil {
+		t.Fatal(err)
+	}
+	changes := snapshotter.Changes()
+	testutil.CheckDeepEqual(t, []string{filepath.Join(testDir, "bar/new")}, changes.Added)
+	testutil.CheckDeepEqual(t, []string{filepath.Join(testDir, "bar/bat")}, changes.Deleted)
+	// The unchanged parent directories aren't modified
+	sort.Strings(changes.Modified)
+	testutil.CheckDeepEqual(t, []string{filepath.Join(testDir, "bar"), filepath.Join(testDir, "foo")}, changes.Modified)
+
+	if err := testutil.SetupFiles(testDir, map[string]string{"foo": "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshotter.TakeSnapshot([]string{filepath.Join(testDir, "foo")}); err != nil {
+		t.Fatal(err)
+	}
+	changes = snapshotter.Changes()
+	testutil.CheckDeepEqual(t, []string(nil), changes.Added)
+	testutil.CheckDeepEqual(t, []string{filepath.Join(testDir, "foo")}, changes.Modified)
+}
+
+// checkTarContents checks that the tar at tarPath contains the expected paths and none of the unexpected ones,
+// relative to testDir
+func checkTarContents(t *testing.T, testDir, tarPath string, expected, unexpected []string) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	names := map[string]bool{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[hdr.Name] = true
+	}
+	testDirWithoutLeadingSlash := strings.TrimLeft(testDir, "/")
+	for _, name := range expected {
+		if !names[filepath.Join(testDirWithoutLeadingSlash, name)] {
+			t.Errorf("Expected %s in tar, found %v", name, names)
+		}
+	}
+	for _, name := range unexpected {
+		if names[filepath.Join(testDirWithoutLeadingSlash, name)] {
+			t.Errorf("File %s unexpectedly in tar", name)
+		}
+	}
+}
+
+// fakeTracker reports the paths in changes as changed
+type fakeTracker struct {
+	changes  map[string]bool
+	complete bool
+}
+
+func (f *fakeTracker) Start(_ string) error { return nil }
+func (f *fakeTracker) Changes() (map[string]bool, bool) {
+	return f.changes, f.complete
+}
+func (f *fakeTracker) Close() error { return nil }
+
+func TestSnapshotFSTrackedChanges(t *testing.T) {
+	tests := []struct {
+		description string
+		complete    bool
+		expected    []string
+	}{
+		{
+			description: "only tracked and new files are checked",
+			complete:    true,
+			expected:    []string{"foo", "new"},
+		},
+		{
+			description: "every file is checked if changes were missed",
+			complete:    false,
+			expected:    []string{"bar/bat", "foo", "new"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			tracker := &fakeTracker{complete: test.complete}
+			testDir, snapshotter, cleanup, err := setUpTestDirWithTracker(tracker)
+			defer cleanup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracker.changes = map[string]bool{filepath.Join(testDir, "foo"): true}
+			if err := testutil.SetupFiles(testDir, map[string]string{
+				"foo":     "newbaz1",
+				"bar/bat": "newbaz2",
+				"new":     "new",
+			}); err != nil {
+				t.Fatalf("Error setting up fs: %s", err)
+			}
+			tarPath, err := snapshotter.TakeSnapshotFS()
+			if err != nil {
+				t.Fatalf("Error taking snapshot of fs: %s", err)
+			}
+
+			f, err := os.Open(tarPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			tr := tar.NewReader(f)
+			testDirWithoutLeadingSlash := strings.TrimLeft(testDir, "/")
+			var filesInTar []string
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if hdr.Typeflag == tar.TypeReg {
+					filesInTar = append(filesInTar, strings.TrimPrefix(hdr.Name, testDirWithoutLeadingSlash+"/"))
+				}
+			}
+			testutil.CheckDeepEqual(t, test.expected, filesInTar)
+		})
+	}
+}
+
 func setUpTestDir() (string, *Snapshotter, func(), error) {
+	return setUpTestDirWithTracker(nil)
+}
+
+func setUpTestDirWithTracker(tracker ChangeTracker) (string, *Snapshotter, func(), error) {
 	testDir, err := ioutil.TempDir("", "")
 	if err != nil {
 		return "", nil, nil, errors.Wrap(err, "setting up temp dir")
@@ -279,11 +512,15 @@ func setUpTestDir() (string, *Snapshotter, func(), error) {
 	// Take the initial snapshot
 	l := NewLayeredMap(util.Hasher(), util.CacheHasher())
 	snapshotter := NewSnapshotter(l, testDir)
+	if tracker != nil {
+		snapshotter.TrackChanges(tracker)
+	}
 	if err := snapshotter.Init(); err != nil {
 		return "", nil, nil, errors.Wrap(err, "initializing snapshotter")
 	}
 
 	cleanup := func() {
+		snapshotter.Close()
 		os.RemoveAll(snapshotPath)
 		os.RemoveAll(testDir)
 	}
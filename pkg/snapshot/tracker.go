@@ -0,0 +1,29 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+// ChangeTracker records the paths changed under a directory, so that snapshots of the full filesystem
+// only need to hash those rather than every file
+type ChangeTracker interface {
+	// Start starts recording changes to the files under dir
+	Start(dir string) error
+	// Changes returns the paths changed since Start or the previous call to Changes,
+	// and false if changes may have been missed, in which case every file needs to be checked
+	Changes() (map[string]bool, bool)
+	// Close stops recording changes
+	Close() error
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+		syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+	// How often queued events are read, so the kernel queue doesn't overflow during long commands
+	inotifyPollInterval = 50 * time.Millisecond
+)
+
+// inotifyTracker is a ChangeTracker which watches every directory with inotify.
+// inotify doesn't report writes through a memory mapping, or through a hard link in a
+// directory which isn't watched, e.g. outside of the snapshotted directory, so those are missed.
+// The filesystem is still walked for every snapshot, to find the files new to the layered map.
+type inotifyTracker struct {
+	fd        int
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	mu      sync.Mutex
+	dirs    map[int32]string
+	changed map[string]bool
+	// missed is set when events overflowed the queue since the last call to Changes
+	missed bool
+	// broken is set when a directory couldn't be watched, e.g. because the limit of watches was reached
+	broken bool
+}
+
+// NewInotifyTracker returns a ChangeTracker which uses inotify
+func NewInotifyTracker() (ChangeTracker, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "initializing inotify")
+	}
+	return &inotifyTracker{
+		fd:      fd,
+		done:    make(chan struct{}),
+		dirs:    map[int32]string{},
+		changed: map[string]bool{},
+	}, nil
+}
+
+// Start implements ChangeTracker.
+func (t *inotifyTracker) Start(dir string) error {
+	t.mu.Lock()
+	t.watchTree(dir, false)
+	broken := t.broken
+	t.mu.Unlock()
+	if broken {
+		return errors.New("unable to watch every directory")
+	}
+	logrus.Debugf("Watching %d directories for changes", len(t.dirs))
+
+	go func() {
+		ticker := time.NewTicker(inotifyPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				t.mu.Lock()
+				t.readEvents()
+				t.mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+// Changes implements ChangeTracker.
+func (t *inotifyTracker) Changes() (map[string]bool, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readEvents()
+	changed, complete := t.changed, !t.missed && !t.broken
+	t.changed = map[string]bool{}
+	t.missed = false
+	return changed, complete
+}
+
+// Close implements ChangeTracker. It may be called more than once.
+func (t *inotifyTracker) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.closeErr = syscall.Close(t.fd)
+	})
+	return t.closeErr
+}
+
+// watchTree watches dir and every directory under it. When markChanged is set, e.g. for a
+// directory created after Start, everything in it is marked as changed too.
+func (t *inotifyTracker) watchTree(dir string, markChanged bool) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The path was removed while walking.
+			return nil
+		}
+		if util.IsInWhitelist(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if markChanged {
+			t.changed[path] = true
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(t.fd, path, inotifyMask)
+		if err != nil {
+			if !t.broken {
+				logrus.Warnf("Unable to watch %s for changes, checking every file when snapshotting: %s", path, err)
+			}
+			t.broken = true
+			return filepath.SkipDir
+		}
+		t.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+// readEvents records the paths of the queued events until there are none left
+func (t *inotifyTracker) readEvents() {
+	var buf [syscall.SizeofInotifyEvent * 4096]byte
+	for {
+		n, err := syscall.Read(t.fd, buf[:])
+		if err != nil || n <= 0 {
+			// EAGAIN once the queue is empty
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			t.handleEvent(event, string(trimNull(nameBytes)))
+		}
+	}
+}
+
+func (t *inotifyTracker) handleEvent(event *syscall.InotifyEvent, name string) {
+	if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		logrus.Debug("Overflowed the queue of changes, checking every file when snapshotting")
+		t.missed = true
+		return
+	}
+	dir, ok := t.dirs[event.Wd]
+	if !ok {
+		return
+	}
+	if event.Mask&syscall.IN_IGNORED != 0 {
+		delete(t.dirs, event.Wd)
+		return
+	}
+	t.changed[dir] = true
+	if name == "" {
+		return
+	}
+	path := filepath.Join(dir, name)
+	t.changed[path] = true
+	// Directories created or moved here need to be watched too.
+	if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		t.watchTree(path, true)
+	}
+}
+
+func trimNull(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func TestInotifyTracker(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+	if err := testutil.SetupFiles(testDir, map[string]string{
+		"foo":     "baz1",
+		"bar/bat": "baz2",
+		"baz/qux": "baz3",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker, err := NewInotifyTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+	if err := tracker.Start(testDir); err != nil {
+		t.Fatal(err)
+	}
+	// Files which existed before tracking started aren't changed until they are touched.
+	changes, complete := tracker.Changes()
+	testutil.CheckDeepEqual(t, true, complete)
+	testutil.CheckDeepEqual(t, map[string]bool{}, changes)
+
+	if err := testutil.SetupFiles(testDir, map[string]string{
+		"bar/bat":  "changed",
+		"new/file": "new",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(testDir, "foo")); err != nil {
+		t.Fatal(err)
+	}
+	changes, complete = tracker.Changes()
+	testutil.CheckDeepEqual(t, true, complete)
+	for _, path := range []string{"foo", "bar", "bar/bat", "new", "new/file"} {
+		if !changes[filepath.Join(testDir, path)] {
+			t.Errorf("expected %s to be changed, got %v", path, changes)
+		}
+	}
+	if changes[filepath.Join(testDir, "baz/qux")] {
+		t.Errorf("expected baz/qux to be unchanged")
+	}
+
+	// Directories created while tracking are watched too.
+	if err := ioutil.WriteFile(filepath.Join(testDir, "new/file"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changes, _ = tracker.Changes()
+	testutil.CheckDeepEqual(t, true, changes[filepath.Join(testDir, "new/file")])
+}
+
+func TestInotifyTracker_CloseTwice(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+	tracker, err := NewInotifyTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Start(testDir); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckError(t, false, tracker.Close())
+	testutil.CheckError(t, false, tracker.Close())
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TLSConfig returns the TLS configuration to use for connections to registry, or nil if the default one should be used.
+// Verification is skipped if skipVerify is set, otherwise the CA bundles set for registry with --registry-certificate
+// are trusted in addition to the system ones. Client certificates set with --registry-client-cert are presented in both cases.
+func TLSConfig(opts *config.KanikoOptions, registry string, skipVerify bool) (*tls.Config, error) {
+	caFiles, err := registryValues(opts.RegistryCertificates, registry)
+	if err != nil {
+		return nil, err
+	}
+	clientCerts, err := registryValues(opts.RegistryClientCerts, registry)
+	if err != nil {
+		return nil, err
+	}
+	if !skipVerify && len(caFiles) == 0 && len(clientCerts) == 0 {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: skipVerify}
+	if !skipVerify && len(caFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			logrus.Warnf("Unable to load the system certificates, only trusting the certificates set for %s: %s", registry, err)
+			pool = x509.NewCertPool()
+		}
+		for _, caFile := range caFiles {
+			pem, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading certificate for %s", registry)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM certificates found in %s", caFile)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	for _, clientCert := range clientCerts {
+		parts := strings.SplitN(clientCert, ",", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("client certificate for %s must be of the form cert,key", registry)
+		}
+		cert, err := tls.LoadX509KeyPair(parts[0], parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading client certificate for %s", registry)
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+	return tlsConfig, nil
+}
+
+// registryValues returns the values set for registry in values, which is keyed by registry
+func registryValues(values map[string][]string, registry string) ([]string, error) {
+	var matching []string
+	for key, vs := range values {
+		reg, err := name.NewRegistry(key, name.WeakValidation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing registry %s", key)
+		}
+		if reg.RegistryStr() == registry {
+			matching = append(matching, vs...)
+		}
+	}
+	return matching, nil
+}
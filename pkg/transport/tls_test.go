@@ -0,0 +1,129 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+// writeCertificate writes a self-signed certificate and its key to dir
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "registry.internal"},
+		DNSNames:     []string{"registry.internal"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func Test_TLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeCertificate(t, dir)
+
+	opts := &config.KanikoOptions{
+		RegistryCertificates: map[string][]string{"registry.internal": {certPath}},
+		RegistryClientCerts:  map[string][]string{"registry.internal": {certPath + "," + keyPath}},
+	}
+
+	t.Run("no configuration", func(t *testing.T) {
+		tlsConfig, err := TLSConfig(opts, "gcr.io", false)
+		testutil.CheckError(t, false, err)
+		testutil.CheckDeepEqual(t, true, tlsConfig == nil)
+	})
+
+	t.Run("skip verify", func(t *testing.T) {
+		tlsConfig, err := TLSConfig(opts, "gcr.io", true)
+		testutil.CheckError(t, false, err)
+		testutil.CheckDeepEqual(t, true, tlsConfig.InsecureSkipVerify)
+		testutil.CheckDeepEqual(t, true, tlsConfig.RootCAs == nil)
+	})
+
+	t.Run("certificates", func(t *testing.T) {
+		tlsConfig, err := TLSConfig(opts, "registry.internal", false)
+		testutil.CheckError(t, false, err)
+		testutil.CheckDeepEqual(t, false, tlsConfig.InsecureSkipVerify)
+		testutil.CheckDeepEqual(t, 1, len(tlsConfig.Certificates))
+
+		b, err := ioutil.ReadFile(certPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(b)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{Roots: tlsConfig.RootCAs, DNSName: "registry.internal"})
+		testutil.CheckError(t, false, err)
+	})
+
+	t.Run("invalid certificate", func(t *testing.T) {
+		opts := &config.KanikoOptions{
+			RegistryCertificates: map[string][]string{"registry.internal": {keyPath}},
+		}
+		_, err := TLSConfig(opts, "registry.internal", false)
+		testutil.CheckError(t, true, err)
+	})
+
+	t.Run("invalid client certificate", func(t *testing.T) {
+		opts := &config.KanikoOptions{
+			RegistryClientCerts: map[string][]string{"registry.internal": {certPath}},
+		}
+		_, err := TLSConfig(opts, "registry.internal", false)
+		testutil.CheckError(t, true, err)
+	})
+}
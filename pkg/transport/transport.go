@@ -0,0 +1,90 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/version"
+)
+
+const (
+	// UpstreamClientUaKey is the environment variable naming the client running kaniko, which is added to the user-agent
+	UpstreamClientUaKey = "UPSTREAM_CLIENT_TYPE"
+
+	dialTimeout           = 30 * time.Second
+	keepAlive             = 30 * time.Second
+	idleConnTimeout       = 90 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	expectContinueTimeout = 1 * time.Second
+	maxIdleConns          = 100
+)
+
+// DefaultClient is the client for HTTP requests which aren't made to a registry,
+// such as fetching build contexts, Dockerfiles and the URLs in ADD
+var DefaultClient = &http.Client{Transport: NewDefault()}
+
+// New returns a transport for connections to registry which isn't shared with any other.
+// It uses the proxy set in the environment, the TLS configuration returned by TLSConfig and kaniko's user-agent.
+func New(opts *config.KanikoOptions, registry string, skipVerify bool) (http.RoundTripper, error) {
+	tlsConfig, err := TLSConfig(opts, registry, skipVerify)
+	if err != nil {
+		return nil, err
+	}
+	return newTransport(tlsConfig), nil
+}
+
+// NewDefault returns a transport with the default TLS configuration which isn't shared with any other.
+// It uses the proxy set in the environment and kaniko's user-agent.
+func NewDefault() http.RoundTripper {
+	return newTransport(nil)
+}
+
+func newTransport(tlsConfig *tls.Config) http.RoundTripper {
+	return &withUserAgent{t: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		MaxIdleConns:          maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: expectContinueTimeout,
+		TLSClientConfig:       tlsConfig,
+	}}
+}
+
+type withUserAgent struct {
+	t http.RoundTripper
+}
+
+func (w *withUserAgent) RoundTrip(r *http.Request) (*http.Response, error) {
+	ua := []string{fmt.Sprintf("kaniko/%s", version.Version())}
+	if upstream := os.Getenv(UpstreamClientUaKey); upstream != "" {
+		ua = append(ua, upstream)
+	}
+	r.Header.Set("User-Agent", strings.Join(ua, ","))
+	return w.t.RoundTrip(r)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func TestHeaderAdded(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		expected string
+	}{{
+		name:     "upstream env variable set",
+		upstream: "skaffold-v0.25.45",
+		expected: "kaniko/unset,skaffold-v0.25.45",
+	}, {
+		name:     "upstream env variable not set",
+		expected: "kaniko/unset",
+	},
+	}
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+			rt := &withUserAgent{t: &mockRoundTripper{}}
+			if test.upstream != "" {
+				os.Setenv("UPSTREAM_CLIENT_TYPE", test.upstream)
+				defer func() { os.Unsetenv("UPSTREAM_CLIENT_TYPE") }()
+			}
+			req, err := http.NewRequest("GET", "dummy", nil)
+			if err != nil {
+				t.Fatalf("culd not create a req due to %s", err)
+			}
+			resp, err := rt.RoundTrip(req)
+			testutil.CheckError(t, false, err)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, string(body))
+		})
+	}
+
+}
+
+type mockRoundTripper struct {
+}
+
+func (m *mockRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	ua := r.UserAgent()
+	return &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(ua))}, nil
+}
+
+func TestNew_Isolated(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// The handshake errors of the clients which don't trust the server are expected
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	opts := &config.KanikoOptions{}
+	insecure, err := New(opts, "insecure.io", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secure, err := New(opts, "secure.io", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if insecure == secure || insecure.(*withUserAgent).t == secure.(*withUserAgent).t {
+		t.Fatal("expected transports for different registries not to be shared")
+	}
+
+	// Only the transport of the registry which skips verification trusts the test server
+	tests := []struct {
+		name      string
+		transport http.RoundTripper
+		shouldErr bool
+	}{
+		{name: "insecure registry", transport: insecure},
+		{name: "secure registry", transport: secure, shouldErr: true},
+		{name: "default", transport: NewDefault(), shouldErr: true},
+		{name: "http.DefaultTransport", transport: http.DefaultTransport, shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := (&http.Client{Transport: test.transport}).Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			testutil.CheckError(t, test.shouldErr, err)
+		})
+	}
+
+	if cfg := http.DefaultTransport.(*http.Transport).TLSClientConfig; cfg != nil && cfg.InsecureSkipVerify {
+		t.Error("expected http.DefaultTransport not to skip TLS verification")
+	}
+}
@@ -18,14 +18,15 @@ package util
 
 import (
 	"fmt"
-	"net/http"
 	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/transport"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
@@ -61,7 +62,7 @@ func ResolveEnvironmentReplacement(value string, envs []string, isFilepath bool)
 	shlex := shell.NewLex(parser.DefaultEscapeToken)
 	fp, err := shlex.ProcessWord(value, envs)
 	// Check after replacement if value is a remote URL
-	if !isFilepath || IsSrcRemoteFileURL(fp) {
+	if !isFilepath || IsSrcRemote(fp) {
 		return fp, err
 	}
 	if err != nil {
@@ -127,7 +128,7 @@ func ResolveSources(srcs []string, root string) ([]string, error) {
 func matchSources(srcs, files []string) ([]string, error) {
 	var matchedSources []string
 	for _, src := range srcs {
-		if IsSrcRemoteFileURL(src) {
+		if IsSrcRemote(src) {
 			matchedSources = append(matchedSources, src)
 			continue
 		}
@@ -220,7 +221,7 @@ func IsSrcsValid(srcsAndDest instructions.SourcesAndDest, resolvedSources []stri
 
 	// If there is only one source and it's a directory, docker assumes the dest is a directory
 	if len(resolvedSources) == 1 {
-		if IsSrcRemoteFileURL(resolvedSources[0]) {
+		if IsSrcRemote(resolvedSources[0]) {
 			return nil
 		}
 		path := filepath.Join(root, resolvedSources[0])
@@ -235,7 +236,7 @@ func IsSrcsValid(srcsAndDest instructions.SourcesAndDest, resolvedSources []stri
 
 	totalFiles := 0
 	for _, src := range resolvedSources {
-		if IsSrcRemoteFileURL(src) {
+		if IsSrcRemote(src) {
 			totalFiles++
 			continue
 		}
@@ -267,8 +268,12 @@ func IsSrcRemoteFileURL(rawurl string) bool {
 	if err != nil {
 		return false
 	}
-	_, err = http.Get(rawurl)
-	return err == nil
+	resp, err := transport.DefaultClient.Get(rawurl)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
 }
 
 func UpdateConfigEnv(newEnvs []instructions.KeyValuePair, config *v1.Config, replacementEnvs []string) error {
@@ -358,3 +363,33 @@ func GetUserFromUsername(userStr string, groupStr string) (string, string, error
 
 	return uid, gid, nil
 }
+
+// GetUIDAndGIDFromString resolves a user[:group] string, where user and group
+// can be either names or ids, to a uid and gid
+func GetUIDAndGIDFromString(userGroupString string) (uint32, uint32, error) {
+	userAndGroup := strings.Split(userGroupString, ":")
+	userStr := userAndGroup[0]
+	var groupStr string
+	if len(userAndGroup) > 1 {
+		groupStr = userAndGroup[1]
+	}
+
+	uidStr, gidStr, err := GetUserFromUsername(userStr, groupStr)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// uid and gid need to be uint32
+	uid64, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	var gid64 uint64
+	if gidStr != "" {
+		gid64, err = strconv.ParseUint(gidStr, 10, 32)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return uint32(uid64), uint32(gid64), nil
+}
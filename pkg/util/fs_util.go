@@ -20,7 +20,11 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -31,6 +35,7 @@ import (
 	"time"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/transport"
 	"github.com/docker/docker/builder/dockerignore"
 	"github.com/docker/docker/pkg/fileutils"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -103,6 +108,8 @@ func GetFSFromImage(root string, img v1.Image, extract ExtractFunction) ([]strin
 		}
 		defer r.Close()
 		tr := tar.NewReader(r)
+		// The paths in this layer, which opaque whiteouts don't hide
+		layerPaths := map[string]bool{}
 		for {
 			hdr, err := tr.Next()
 			if err == io.EOF {
@@ -114,9 +121,16 @@ func GetFSFromImage(root string, img v1.Image, extract ExtractFunction) ([]strin
 			path := filepath.Join(root, filepath.Clean(hdr.Name))
 			base := filepath.Base(path)
 			dir := filepath.Dir(path)
-			if strings.HasPrefix(base, ".wh.") {
+			if base == constants.OpaqueWhiteout {
+				logrus.Debugf("Hiding the contents of %s in lower layers", dir)
+				if err := removeLowerContents(dir, layerPaths); err != nil {
+					return nil, errors.Wrapf(err, "applying opaque whiteout %s", hdr.Name)
+				}
+				continue
+			}
+			if strings.HasPrefix(base, constants.WhiteoutPrefix) {
 				logrus.Debugf("Whiting out %s", path)
-				name := strings.TrimPrefix(base, ".wh.")
+				name := strings.TrimPrefix(base, constants.WhiteoutPrefix)
 				if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
 					return nil, errors.Wrapf(err, "removing whiteout %s", hdr.Name)
 				}
@@ -125,12 +139,50 @@ func GetFSFromImage(root string, img v1.Image, extract ExtractFunction) ([]strin
 			if err := extract(root, hdr, tr); err != nil {
 				return nil, err
 			}
+			layerPaths[path] = true
 			extractedFiles = append(extractedFiles, filepath.Join(root, filepath.Clean(hdr.Name)))
 		}
 	}
 	return extractedFiles, nil
 }
 
+// removeLowerContents removes what is in dir, except for the paths extracted from the current layer
+// and whitelisted paths, for an opaque whiteout.
+func removeLowerContents(dir string, layerPaths map[string]bool) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if CheckWhitelist(path) {
+			continue
+		}
+		if layerPaths[path] {
+			// Directories from this layer may still contain paths from lower layers
+			if entry.IsDir() {
+				if err := removeLowerContents(path, layerPaths); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if ChildDirInWhitelist(path) {
+			if err := removeLowerContents(path, layerPaths); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DeleteFilesystem deletes the extracted image file system
 func DeleteFilesystem() error {
 	logrus.Info("Deleting filesystem...")
@@ -146,7 +198,7 @@ func DeleteFilesystem() error {
 			logrus.Debugf("Not deleting %s, as it's whitelisted", path)
 			return nil
 		}
-		if childDirInWhitelist(path) {
+		if ChildDirInWhitelist(path) {
 			logrus.Debugf("Not deleting %s, as it contains a whitelisted path", path)
 			return nil
 		}
@@ -166,7 +218,7 @@ func isExist(path string) bool {
 }
 
 // ChildDirInWhitelist returns true if there is a child file or directory of the path in the whitelist
-func childDirInWhitelist(path string) bool {
+func ChildDirInWhitelist(path string) bool {
 	for _, d := range whitelist {
 		if HasFilepathPrefix(d.Path, path, d.PrefixMatchOnly) {
 			return true
@@ -213,7 +265,7 @@ func ExtractFile(dest string, hdr *tar.Header, tr io.Reader) error {
 		return nil
 	}
 	switch hdr.Typeflag {
-	case tar.TypeReg:
+	case tar.TypeReg, tar.TypeGNUSparse:
 		logrus.Tracef("creating file %s", path)
 		// It's possible a file is in the tar before its directory,
 		// or a file was copied over a directory prior to now
@@ -236,18 +288,30 @@ func ExtractFile(dest string, hdr *tar.Header, tr io.Reader) error {
 		if err != nil {
 			return err
 		}
-		if _, err = io.Copy(currFile, tr); err != nil {
+		if isSparse(hdr) {
+			err = copySparse(currFile, tr, hdr.Size)
+		} else {
+			_, err = io.Copy(currFile, tr)
+		}
+		if err != nil {
 			return err
 		}
 		if err = setFilePermissions(path, mode, uid, gid); err != nil {
 			return err
 		}
+		// chown clears file capabilities, so xattrs are set afterwards
+		if err := setXattrsFromHeader(path, hdr); err != nil {
+			return err
+		}
 		currFile.Close()
 	case tar.TypeDir:
 		logrus.Tracef("creating dir %s", path)
 		if err := mkdirAllWithPermissions(path, mode, uid, gid); err != nil {
 			return err
 		}
+		if err := setXattrsFromHeader(path, hdr); err != nil {
+			return err
+		}
 
 	case tar.TypeLink:
 		logrus.Tracef("link from %s to %s", hdr.Linkname, path)
@@ -291,10 +355,99 @@ func ExtractFile(dest string, hdr *tar.Header, tr io.Reader) error {
 		if err := os.Symlink(hdr.Linkname, path); err != nil {
 			return err
 		}
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		logrus.Tracef("creating special file %s", path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		// Check if something already exists at path
+		// If so, delete it
+		if FilepathExists(path) {
+			if err := os.RemoveAll(path); err != nil {
+				return errors.Wrapf(err, "error removing %s to make way for new special file", hdr.Name)
+			}
+		}
+		if err := mknod(path, hdr); err != nil {
+			if os.IsPermission(err) {
+				logrus.Warnf("Not creating special file %s since kaniko isn't allowed to: %s", path, err)
+				return nil
+			}
+			return errors.Wrapf(err, "creating special file %s", path)
+		}
+		if err := setFilePermissions(path, mode, uid, gid); err != nil {
+			return err
+		}
+
+	case tar.TypeXGlobalHeader:
+		// Only holds metadata for the entries after it
+
+	default:
+		logrus.Warnf("Not extracting %s since its tar type %q isn't supported", path, hdr.Typeflag)
 	}
 	return nil
 }
 
+// mknod creates the device or FIFO described by hdr at path
+func mknod(path string, hdr *tar.Header) error {
+	perm := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		return syscall.Mknod(path, syscall.S_IFCHR|perm, mkdev(hdr.Devmajor, hdr.Devminor))
+	case tar.TypeBlock:
+		return syscall.Mknod(path, syscall.S_IFBLK|perm, mkdev(hdr.Devmajor, hdr.Devminor))
+	default:
+		return syscall.Mkfifo(path, perm)
+	}
+}
+
+// isSparse returns true if hdr describes a sparse file, in the old GNU format or one of the PAX formats
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// copySparse copies the contents of a sparse file from r to f, leaving holes where blocks are all zeros
+func copySparse(f *os.File, r io.Reader, size int64) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if isZeros(buf[:n]) {
+				if _, err := f.Seek(int64(n), io.SeekCurrent); err != nil {
+					return err
+				}
+			} else if _, err := f.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// Holes at the end of the file aren't written
+	return f.Truncate(size)
+}
+
+func isZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func IsInWhitelist(path string) bool {
 	for _, wl := range whitelist {
 		if !wl.PrefixMatchOnly && path == wl.Path {
@@ -328,7 +481,7 @@ func checkWhitelistRoot(root string) bool {
 // Where (5) is the mount point relative to the process's root
 // From: https://www.kernel.org/doc/Documentation/filesystems/proc.txt
 func DetectFilesystemWhitelist(path string) error {
-	whitelist = initialWhitelist
+	whitelist = append([]WhitelistEntry{}, initialWhitelist...)
 	volumes = []string{}
 	f, err := os.Open(path)
 	if err != nil {
@@ -455,6 +608,14 @@ func CreateFile(path string, reader io.Reader, perm os.FileMode, uid uint32, gid
 	return setFilePermissions(path, perm, int(uid), int(gid))
 }
 
+// AddToBaseWhitelist adds the given entry to the whitelist of every stage,
+// e.g. for runtime paths that should never end up in a snapshot.
+func AddToBaseWhitelist(entry WhitelistEntry) {
+	logrus.Infof("adding %s to base whitelist", entry.Path)
+	initialWhitelist = append(initialWhitelist, entry)
+	whitelist = append(whitelist, entry)
+}
+
 // AddVolumePath adds the given path to the volume whitelist.
 func AddVolumePathToWhitelist(path string) {
 	logrus.Infof("adding volume %s to whitelist", path)
@@ -467,19 +628,42 @@ func AddVolumePathToWhitelist(path string) {
 
 // DownloadFileToDest downloads the file at rawurl to the given dest for the ADD command
 // From add command docs:
-// 	1. If <src> is a remote file URL:
-// 		- destination will have permissions of 0600
-// 		- If remote file has HTTP Last-Modified header, we set the mtime of the file to that timestamp
-func DownloadFileToDest(rawurl, dest string) error {
-	resp, err := http.Get(rawurl)
+//  1. If <src> is a remote file URL:
+//     - destination will have permissions of 0600
+//     - If remote file has HTTP Last-Modified header, we set the mtime of the file to that timestamp
+//
+// If checksum is set, e.g. sha256:<hex>, the downloaded file must match it.
+func DownloadFileToDest(rawurl, dest, checksum string) error {
+	var h hash.Hash
+	var expected string
+	if checksum != "" {
+		var err error
+		if h, expected, err = parseChecksum(checksum); err != nil {
+			return err
+		}
+	}
+	resp, err := transport.DefaultClient.Get(rawurl)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("downloading %s: %s", rawurl, resp.Status)
+	}
+	var body io.Reader = resp.Body
+	if h != nil {
+		body = io.TeeReader(resp.Body, h)
+	}
 	// TODO: set uid and gid according to current user
-	if err := CreateFile(dest, resp.Body, 0600, 0, 0); err != nil {
+	if err := CreateFile(dest, body, 0600, 0, 0); err != nil {
 		return err
 	}
+	if h != nil {
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+			os.Remove(dest)
+			return fmt.Errorf("checksum mismatch for %s: expected %s but got %s", rawurl, expected, actual)
+		}
+	}
 	mTime := time.Time{}
 	lastMod := resp.Header.Get("Last-Modified")
 	if lastMod != "" {
@@ -490,6 +674,46 @@ func DownloadFileToDest(rawurl, dest string) error {
 	return os.Chtimes(dest, mTime, mTime)
 }
 
+// parseChecksum returns the hash and the expected hex digest for a checksum such as sha256:<hex>
+func parseChecksum(checksum string) (hash.Hash, string, error) {
+	parts := strings.SplitN(checksum, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, "", fmt.Errorf("invalid checksum %s: expected <algorithm>:<hex digest>", checksum)
+	}
+	var h hash.Hash
+	switch parts[0] {
+	case "sha256":
+		h = sha256.New()
+	case "sha384":
+		h = sha512.New384()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, "", fmt.Errorf("unsupported checksum algorithm %s", parts[0])
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil || len(parts[1]) != 2*h.Size() {
+		return nil, "", fmt.Errorf("invalid %s digest %s", parts[0], parts[1])
+	}
+	return h, parts[1], nil
+}
+
+// RemoteFileVersion returns the ETag, or else the Last-Modified time, of the file at rawurl,
+// and an empty string if the server provides neither
+func RemoteFileVersion(rawurl string) (string, error) {
+	resp, err := transport.DefaultClient.Head(rawurl)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("checking %s: %s", rawurl, resp.Status)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+	return resp.Header.Get("Last-Modified"), nil
+}
+
 // CopyDir copies the file or directory at src to dest
 // It returns a list of files it copied over
 func CopyDir(src, dest, buildcontext string) ([]string, error) {
@@ -581,13 +805,19 @@ func CopyFile(src, dest, buildcontext string) (bool, error) {
 }
 
 // GetExcludedFiles gets a list of files to exclude from the .dockerignore
-func GetExcludedFiles(dockerfilepath string, buildcontext string) error {
-	path := dockerfilepath + ".dockerignore"
-	if !FilepathExists(path) {
-		path = filepath.Join(buildcontext, ".dockerignore")
-	}
-	if !FilepathExists(path) {
-		return nil
+// If ignorefile is set it is always used, otherwise <dockerfile>.dockerignore
+// is preferred over the .dockerignore at the root of the build context
+func GetExcludedFiles(dockerfilepath, buildcontext, ignorefile string) error {
+	excluded = nil
+	path := ignorefile
+	if path == "" {
+		path = dockerfilepath + constants.Dockerignore
+		if !FilepathExists(path) {
+			path = filepath.Join(buildcontext, constants.Dockerignore)
+		}
+		if !FilepathExists(path) {
+			return nil
+		}
 	}
 	logrus.Infof("Using dockerignore file: %v", path)
 	contents, err := ioutil.ReadFile(path)
@@ -0,0 +1,31 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"os"
+)
+
+// mkdev encodes a device number the way the darwin kernel expects it in mknod
+func mkdev(major, minor int64) int {
+	return int(major<<24 | minor&0xffffff)
+}
+
+// dataSegments returns nil since holes aren't detected on darwin
+func dataSegments(f *os.File, size int64) [][2]int64 {
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io"
+	"os"
+)
+
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// mkdev encodes a device number the way the linux kernel expects it in mknod
+func mkdev(major, minor int64) int {
+	return int((major&0xfff)<<8 | (major&^0xfff)<<32 | (minor & 0xff) | (minor&^0xff)<<12)
+}
+
+// dataSegments returns the offsets and lengths of the data in f with SEEK_DATA and SEEK_HOLE,
+// or nil if f has no holes or the filesystem can't tell.
+func dataSegments(f *os.File, size int64) [][2]int64 {
+	segments := [][2]int64{}
+	for offset := int64(0); offset < size; {
+		start, err := f.Seek(offset, seekData)
+		if err != nil {
+			// ENXIO when there's no more data, i.e. the file ends with a hole
+			break
+		}
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return nil
+		}
+		if end > size {
+			end = size
+		}
+		segments = append(segments, [2]int64{start, end - start})
+		offset = end
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	if len(segments) == 1 && segments[0] == [2]int64{0, size} {
+		return nil
+	}
+	return segments
+}
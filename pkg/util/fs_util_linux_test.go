@@ -0,0 +1,114 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func Test_mkdev(t *testing.T) {
+	tests := []struct {
+		description string
+		major       int64
+		minor       int64
+		expected    int
+	}{
+		{description: "/dev/null", major: 1, minor: 3, expected: 0x103},
+		{description: "/dev/sda1", major: 8, minor: 1, expected: 0x801},
+		{description: "large minor", major: 253, minor: 0x12345, expected: 0x1230fd45},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			testutil.CheckDeepEqual(t, test.expected, mkdev(test.major, test.minor))
+		})
+	}
+}
+
+func TestSparseFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A hole, some data, and another hole at the end
+	const size = 4 << 20
+	path := filepath.Join(dir, "sparse")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("data"), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	expected := make([]byte, size)
+	copy(expected[1<<20:], "data")
+
+	var buf bytes.Buffer
+	tw := NewTar(&buf)
+	if err := tw.AddFileToTar(path); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, true, bytes.Equal(expected, contents))
+
+	// Sparse entries are extracted with holes
+	dest, err := ioutil.TempDir("", "sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+	hdr.Name = "extracted"
+	hdr.PAXRecords = map[string]string{"GNU.sparse.major": "1", "GNU.sparse.minor": "0"}
+	if err := ExtractFile(dest, hdr, bytes.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+	extracted := filepath.Join(dest, "extracted")
+	actual, err := ioutil.ReadFile(extracted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, true, bytes.Equal(expected, actual))
+	fi, err := os.Stat(extracted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated := fi.Sys().(*syscall.Stat_t).Blocks * 512; allocated >= size {
+		t.Errorf("expected %s to have holes, but %d bytes are allocated", extracted, allocated)
+	}
+}
@@ -21,6 +21,8 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -528,6 +530,17 @@ func dirHeader(name string, mode int64) *tar.Header {
 	}
 }
 
+func fifoHeader(name string, mode int64) *tar.Header {
+	return &tar.Header{
+		Name:     name,
+		Size:     0,
+		Typeflag: tar.TypeFifo,
+		Mode:     mode,
+		Uid:      os.Getuid(),
+		Gid:      os.Getgid(),
+	}
+}
+
 func TestExtractFile(t *testing.T) {
 	type tc struct {
 		name     string
@@ -639,6 +652,24 @@ func TestExtractFile(t *testing.T) {
 				permissionsMatch("/foo", 0755|os.ModeDir|os.ModeSticky),
 			},
 		},
+		{
+			name: "fifo",
+			hdrs: []*tar.Header{fifoHeader("./foo/fifo", 0640)},
+			checkers: []checker{
+				permissionsMatch("/foo/fifo", 0640|os.ModeNamedPipe),
+			},
+		},
+		{
+			name:     "fifo replacing a file",
+			contents: []byte("helloworld"),
+			hdrs: []*tar.Header{
+				fileHeader("./fifo", "helloworld", 0644),
+				fifoHeader("./fifo", 0600),
+			},
+			checkers: []checker{
+				permissionsMatch("/fifo", 0600|os.ModeNamedPipe),
+			},
+		},
 	}
 
 	for _, tc := range tcs {
@@ -768,8 +799,8 @@ func Test_childDirInWhitelist(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			whitelist = tt.args.whitelist
-			if got := childDirInWhitelist(tt.args.path); got != tt.want {
-				t.Errorf("childDirInWhitelist() = %v, want %v", got, tt.want)
+			if got := ChildDirInWhitelist(tt.args.path); got != tt.want {
+				t.Errorf("ChildDirInWhitelist() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -779,6 +810,7 @@ func Test_correctDockerignoreFileIsUsed(t *testing.T) {
 	type args struct {
 		dockerfilepath string
 		buildcontext   string
+		ignorefile     string
 		excluded       []string
 		included       []string
 	}
@@ -804,9 +836,19 @@ func Test_correctDockerignoreFileIsUsed(t *testing.T) {
 				included:       []string{"ignore/foo", "ignore_relative/bar"},
 			},
 		},
+		{
+			name: "explicit ignore file is used",
+			args: args{
+				dockerfilepath: "../../integration/dockerfiles/Dockerfile_test_dockerignore",
+				buildcontext:   "../../integration/",
+				ignorefile:     "../../integration/dockerfiles/Dockerfile_dockerignore_relative.dockerignore",
+				excluded:       []string{"ignore_relative/bar"},
+				included:       []string{"ignore_relative/foo", "ignore/bar"},
+			},
+		},
 	}
 	for _, tt := range tests {
-		if err := GetExcludedFiles(tt.args.dockerfilepath, tt.args.buildcontext); err != nil {
+		if err := GetExcludedFiles(tt.args.dockerfilepath, tt.args.buildcontext, tt.args.ignorefile); err != nil {
 			t.Fatal(err)
 		}
 		for _, excl := range tt.args.excluded {
@@ -863,3 +905,94 @@ func Test_CopyFile_skips_self(t *testing.T) {
 		t.Fatalf("expected file contents to be %q, but got %q", expected, actual)
 	}
 }
+
+func Test_DownloadFileToDest_Checksum(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	tmpDir, err := ioutil.TempDir("", "download-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name        string
+		checksum    string
+		shouldError bool
+	}{
+		{name: "no checksum"},
+		{name: "matching checksum", checksum: "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{name: "mismatched checksum", checksum: "sha256:0000000000000000000000000000000000000000000000000000000000000000", shouldError: true},
+		{name: "invalid checksum", checksum: "sha256:abc", shouldError: true},
+		{name: "unsupported algorithm", checksum: "md5:5d41402abc4b2a76b9719d911017c592", shouldError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dest := filepath.Join(tmpDir, "file")
+			err := DownloadFileToDest(server.URL, dest, test.checksum)
+			testutil.CheckError(t, test.shouldError, err)
+			testutil.CheckDeepEqual(t, !test.shouldError, FilepathExists(dest))
+			os.RemoveAll(dest)
+		})
+	}
+}
+
+func Test_RemoteFileVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected string
+	}{
+		{name: "etag", headers: map[string]string{"ETag": `"abc"`, "Last-Modified": "Mon, 02 Jan 2006 15:04:05 GMT"}, expected: `"abc"`},
+		{name: "last modified", headers: map[string]string{"Last-Modified": "Mon, 02 Jan 2006 15:04:05 GMT"}, expected: "Mon, 02 Jan 2006 15:04:05 GMT"},
+		{name: "neither"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range test.headers {
+					w.Header().Set(k, v)
+				}
+			}))
+			defer server.Close()
+			version, err := RemoteFileVersion(server.URL)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, version)
+		})
+	}
+}
+
+func Test_removeLowerContents(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		"dir/lower":          "lower",
+		"dir/sub/lower":      "lower",
+		"dir/sub/layer":      "layer",
+		"dir/lowerdir/lower": "lower",
+		"other/lower":        "lower",
+	}
+	if err := testutil.SetupFiles(root, files); err != nil {
+		t.Fatal(err)
+	}
+	layerPaths := map[string]bool{
+		filepath.Join(root, "dir/sub"):       true,
+		filepath.Join(root, "dir/sub/layer"): true,
+	}
+	err = removeLowerContents(filepath.Join(root, "dir"), layerPaths)
+	testutil.CheckError(t, false, err)
+
+	var remaining []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			remaining = append(remaining, strings.TrimPrefix(path, root+"/"))
+		}
+		return nil
+	})
+	sort.Strings(remaining)
+	testutil.CheckDeepEqual(t, []string{"dir/sub/layer", "other/lower"}, remaining)
+}
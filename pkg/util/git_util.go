@@ -0,0 +1,178 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	git "gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+var (
+	gitURLPattern    = regexp.MustCompile(`^(git://|git@[^:]+:|(https?|ssh|file)://[^#]+\.git(#|$))`)
+	gitCommitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+	// for testing
+	gitCheckoutDir = constants.KanikoDir
+	gitCloneDepth  = 1
+)
+
+// IsSrcGitURL returns true if src refers to a git repository, e.g. https://github.com/org/repo.git#v1.0
+func IsSrcGitURL(src string) bool {
+	return gitURLPattern.MatchString(src)
+}
+
+// IsSrcRemote returns true if src is a git repository or a remote file URL
+func IsSrcRemote(src string) bool {
+	return IsSrcGitURL(src) || IsSrcRemoteFileURL(src)
+}
+
+// parseGitURL splits a git source of the form <repository>#<ref>:<subdirectory> into its parts
+func parseGitURL(src string) (repo, ref, subdir string) {
+	repo = src
+	if i := strings.Index(src, "#"); i >= 0 {
+		repo, ref = src[:i], src[i+1:]
+	}
+	if i := strings.Index(ref, ":"); i >= 0 {
+		ref, subdir = ref[:i], ref[i+1:]
+	}
+	return repo, ref, subdir
+}
+
+// GitRemoteCommit returns the commit the ref of the git source src currently points to
+func GitRemoteCommit(src string) (string, error) {
+	repo, ref, _ := parseGitURL(src)
+	if gitCommitPattern.MatchString(ref) {
+		return ref, nil
+	}
+	r, err := gitRemoteRef(repo, ref)
+	if err != nil {
+		return "", err
+	}
+	return r.Hash().String(), nil
+}
+
+// gitRemoteRef returns the reference of the repository repo named by ref, which is a branch,
+// a tag or a full reference name. It returns the branch HEAD points to if ref is empty.
+func gitRemoteRef(repo, ref string) (*plumbing.Reference, error) {
+	// The vendored go-git can only list remotes of a repository, so use an empty one
+	r, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := r.CreateRemote(&gitconfig.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repo},
+	})
+	if err != nil {
+		return nil, err
+	}
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing the references of %s", repo)
+	}
+	byName := map[plumbing.ReferenceName]*plumbing.Reference{}
+	for _, r := range refs {
+		byName[r.Name()] = r
+	}
+	names := []plumbing.ReferenceName{plumbing.HEAD}
+	if ref != "" {
+		names = []plumbing.ReferenceName{
+			plumbing.ReferenceName(ref),
+			plumbing.ReferenceName("refs/heads/" + ref),
+			plumbing.ReferenceName("refs/tags/" + ref),
+		}
+	}
+	for _, name := range names {
+		r, ok := byName[name]
+		if ok && r.Type() == plumbing.SymbolicReference {
+			r, ok = byName[r.Target()]
+		}
+		if ok {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("ref %s not found in %s", ref, repo)
+}
+
+// CloneGitRepoToDest checks out the ref of the git source src and copies the repository,
+// or the subdirectory given in src, to the directory dest without its .git directory.
+// It returns a list of files it copied over.
+func CloneGitRepoToDest(src, dest string) ([]string, error) {
+	repo, ref, subdir := parseGitURL(src)
+	dir, err := ioutil.TempDir(gitCheckoutDir, "git")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	options := &git.CloneOptions{
+		URL:        repo,
+		NoCheckout: true,
+	}
+	var commit plumbing.Hash
+	if gitCommitPattern.MatchString(ref) {
+		// Commits can't be fetched on their own, so the whole repository is cloned
+		commit = plumbing.NewHash(ref)
+	} else {
+		r, err := gitRemoteRef(repo, ref)
+		if err != nil {
+			return nil, err
+		}
+		options.ReferenceName = r.Name()
+		options.SingleBranch = true
+		options.Depth = gitCloneDepth
+		options.Tags = git.NoTags
+		commit = r.Hash()
+	}
+
+	logrus.Debugf("Fetching %s from %s", ref, repo)
+	r, err := git.PlainClone(dir, false, options)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cloning %s", repo)
+	}
+	// Annotated tags point to a tag object rather than to a commit
+	if tag, err := r.TagObject(commit); err == nil {
+		c, err := tag.Commit()
+		if err != nil {
+			return nil, err
+		}
+		commit = c.Hash
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Hash: commit}); err != nil {
+		return nil, errors.Wrapf(err, "checking out %s from %s", ref, repo)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		return nil, err
+	}
+	return CopyDir(filepath.Join(dir, subdir), dest, "")
+}
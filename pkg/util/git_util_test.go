@@ -0,0 +1,152 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/file"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+)
+
+func Test_IsSrcGitURL(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected bool
+	}{
+		{src: "https://github.com/org/repo.git", expected: true},
+		{src: "https://github.com/org/repo.git#v1.0:docs", expected: true},
+		{src: "git@github.com:org/repo.git", expected: true},
+		{src: "git://example.com/repo", expected: true},
+		{src: "file:///srv/repo.git#main", expected: true},
+		{src: "https://example.com/file.tar.gz"},
+		{src: "https://example.com/repo.git/file"},
+		{src: "repo.git"},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			testutil.CheckDeepEqual(t, test.expected, IsSrcGitURL(test.src))
+		})
+	}
+}
+
+func Test_parseGitURL(t *testing.T) {
+	tests := []struct {
+		src    string
+		repo   string
+		ref    string
+		subdir string
+	}{
+		{src: "https://github.com/org/repo.git", repo: "https://github.com/org/repo.git"},
+		{src: "https://github.com/org/repo.git#v1.0", repo: "https://github.com/org/repo.git", ref: "v1.0"},
+		{src: "git@github.com:org/repo.git#main:docs", repo: "git@github.com:org/repo.git", ref: "main", subdir: "docs"},
+		{src: "git@github.com:org/repo.git#:docs", repo: "git@github.com:org/repo.git", subdir: "docs"},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			repo, ref, subdir := parseGitURL(test.src)
+			testutil.CheckDeepEqual(t, test.repo, repo)
+			testutil.CheckDeepEqual(t, test.ref, ref)
+			testutil.CheckDeepEqual(t, test.subdir, subdir)
+		})
+	}
+}
+
+func Test_CloneGitRepoToDest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "git-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	originalDir, originalDepth := gitCheckoutDir, gitCloneDepth
+	defer func() { gitCheckoutDir, gitCloneDepth = originalDir, originalDepth }()
+	// The in process server doesn't support shallow clones
+	gitCheckoutDir, gitCloneDepth = tmpDir, 0
+
+	repoDir := filepath.Join(tmpDir, "repo.git")
+	repo, err := git.PlainInit(repoDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(content string) string {
+		if err := testutil.SetupFiles(repoDir, map[string]string{"docs/README": content}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Add("docs/README"); err != nil {
+			t.Fatal(err)
+		}
+		h, err := w.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.String()
+	}
+	v1 := commit("v1")
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/tags/v1", plumbing.NewHash(v1))); err != nil {
+		t.Fatal(err)
+	}
+	v2 := commit("v2")
+	// Serve the repository in process rather than with git-upload-pack
+	url := "file://" + repoDir
+	client.InstallProtocol("file", server.NewServer(server.MapLoader{url: repo.Storer}))
+	defer client.InstallProtocol("file", file.DefaultClient)
+
+	tests := []struct {
+		ref      string
+		commit   string
+		expected string
+	}{
+		{ref: "v1", commit: v1, expected: "v1"},
+		{ref: "", commit: v2, expected: "v2"},
+		{ref: "master", commit: v2, expected: "v2"},
+		{ref: "refs/heads/master", commit: v2, expected: "v2"},
+		{ref: v1, commit: v1, expected: "v1"},
+	}
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			src := url + "#" + test.ref + ":docs"
+			actual, err := GitRemoteCommit(src)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.commit, actual)
+
+			dest := filepath.Join(tmpDir, "dest", test.ref)
+			if _, err := CloneGitRepoToDest(src, dest); err != nil {
+				t.Fatal(err)
+			}
+			contents, err := ioutil.ReadFile(filepath.Join(dest, "README"))
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, string(contents))
+			testutil.CheckDeepEqual(t, false, FilepathExists(filepath.Join(dest, ".git")))
+		})
+	}
+
+	_, err = GitRemoteCommit(url + "#missing")
+	testutil.CheckError(t, true, err)
+}
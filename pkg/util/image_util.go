@@ -17,9 +17,9 @@ limitations under the License.
 package util
 
 import (
-	"crypto/tls"
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"net/http"
 	"path/filepath"
 	"strconv"
 
@@ -32,11 +32,13 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/cache"
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/transport"
 )
 
 var (
@@ -70,19 +72,38 @@ func RetrieveSourceImage(stage config.KanikoStage, opts *config.KanikoOptions) (
 		return retrieveTarImage(stage.BaseImageIndex)
 	}
 
+	baseName, err := ApplyImageRewriteRules(currentBaseName, opts)
+	if err != nil {
+		return nil, err
+	}
+	image, err := RetrieveLockedImage(baseName, opts, retrieveImage)
+	if err != nil {
+		return nil, err
+	}
+	if err := RecordImageRewrite(currentBaseName, baseName, image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func retrieveImage(image string, opts *config.KanikoOptions) (v1.Image, error) {
 	// Finally, check if local caching is enabled
 	// If so, look in the local cache before trying the remote registry
 	if opts.CacheDir != "" {
-		cachedImage, err := cachedImage(opts, currentBaseName)
+		cachedImage, err := cachedImage(opts, image)
 		if err != nil {
-			logrus.Errorf("Error while retrieving image from cache: %v %v", currentBaseName, err)
+			logrus.Errorf("Error while retrieving image from cache: %v %v", image, err)
 		} else if cachedImage != nil {
-			return cachedImage, nil
+			ref, err := name.ParseReference(image, name.WeakValidation)
+			if err != nil {
+				return nil, err
+			}
+			return cachedImage, recordLayerOrigins(cachedImage, ref)
 		}
 	}
-	logrus.Infof("Image %v not found in cache", currentBaseName)
+	logrus.Infof("Image %v not found in cache", image)
 	// Otherwise, initialize image as usual
-	return RetrieveRemoteImage(currentBaseName, opts)
+	return RetrieveRemoteImage(image, opts)
 }
 
 func tarballImage(index int) (v1.Image, error) {
@@ -91,14 +112,58 @@ func tarballImage(index int) (v1.Image, error) {
 	return tarball.ImageFromPath(tarPath, nil)
 }
 
-// Retrieves the manifest for the specified image from the specified registry
+// Retrieves the manifest for the specified image from the specified registry,
+// trying the mirrors configured for the registry first
 func remoteImage(image string, opts *config.KanikoOptions) (v1.Image, error) {
+	img, origins, err := pullRemoteImage(image, opts)
+	if err != nil {
+		return nil, err
+	}
+	return img, recordLayerOrigins(img, origins...)
+}
+
+// pullRemoteImage retrieves the manifest of image like remoteImage, and returns
+// the references of the images its layers are known to exist in
+func pullRemoteImage(image string, opts *config.KanikoOptions) (v1.Image, []name.Reference, error) {
 	logrus.Infof("Retrieving image manifest %s", image)
 	ref, err := name.ParseReference(image, name.WeakValidation)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	mirrors, err := config.MirrorReferences(ref, opts.RegistryMirrors)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, mirror := range mirrors {
+		// Fall back to the next mirror rather than retrying this one
+		img, err := pullImage(mirror, opts, 0)
+		if err == nil {
+			logrus.Infof("Retrieved image manifest %s from mirror %s", image, mirror)
+			// The layers are the same as in the original image
+			return img, []name.Reference{mirror, ref}, nil
+		}
+		logrus.Warnf("Failed to retrieve image manifest %s from mirror %s, falling back: %v", image, mirror, err)
+	}
+
+	img, err := pullImage(ref, opts, opts.PullRetry)
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, []name.Reference{ref}, nil
+}
+
+func recordLayerOrigins(img v1.Image, refs ...name.Reference) error {
+	for _, ref := range refs {
+		if err := RecordLayerOrigins(img, ref); err != nil {
+			return errors.Wrapf(err, "recording the origin of the layers of %s", ref)
+		}
 	}
+	return nil
+}
 
+// pullImage retrieves the manifest of ref, retrying transient errors up to retries times
+func pullImage(ref name.Reference, opts *config.KanikoOptions, retries int) (v1.Image, error) {
 	registryName := ref.Context().RegistryStr()
 	if opts.InsecurePull || opts.InsecureRegistries.Contains(registryName) {
 		newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
@@ -115,23 +180,34 @@ func remoteImage(image string, opts *config.KanikoOptions) (v1.Image, error) {
 		}
 	}
 
-	rOpts := remoteOptions(registryName, opts)
+	rOpts, err := remoteOptions(registryName, opts)
 	if err != nil {
 		return nil, err
 	}
-
-	return remote.Image(ref, rOpts...)
+	var img v1.Image
+	err = RetryTransient(func() error {
+		var err error
+		img, err = remote.Image(ref, rOpts...)
+		return err
+	}, retries, constants.RetryDelayMilliseconds)
+	return img, err
 }
 
-func remoteOptions(registryName string, opts *config.KanikoOptions) []remote.Option {
-	tr := http.DefaultTransport.(*http.Transport)
-	if opts.SkipTLSVerifyPull || opts.SkipTLSVerifyRegistries.Contains(registryName) {
-		tr.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+func remoteOptions(registryName string, opts *config.KanikoOptions) ([]remote.Option, error) {
+	tr, err := transport.New(opts, registryName, opts.SkipTLSVerifyPull || opts.SkipTLSVerifyRegistries.Contains(registryName))
+	if err != nil {
+		return nil, err
 	}
 
-	return []remote.Option{remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain())}
+	rOpts := []remote.Option{remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain())}
+	if opts.CustomPlatform != "" {
+		platform, err := config.ParsePlatform(opts.CustomPlatform)
+		if err != nil {
+			return nil, err
+		}
+		rOpts = append(rOpts, remote.WithPlatform(platform))
+	}
+	return rOpts, nil
 }
 
 func cachedImage(opts *config.KanikoOptions, image string) (v1.Image, error) {
@@ -144,7 +220,8 @@ func cachedImage(opts *config.KanikoOptions, image string) (v1.Image, error) {
 	if d, ok := ref.(name.Digest); ok {
 		cacheKey = d.DigestStr()
 	} else {
-		image, err := remoteImage(image, opts)
+		// Only the digest is needed, the origins of the layers are recorded for the cached image
+		image, _, err := pullRemoteImage(image, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -157,3 +234,77 @@ func cachedImage(opts *config.KanikoOptions, image string) (v1.Image, error) {
 	}
 	return cache.LocalSource(&opts.CacheOptions, cacheKey)
 }
+
+// variantImage is an image whose config file sets the platform variant,
+// which v1.ConfigFile has no field for
+type variantImage struct {
+	v1.Image
+	rawConfig   []byte
+	manifest    *v1.Manifest
+	rawManifest []byte
+}
+
+// ImageWithVariant returns img with the variant field of its config file set to variant, e.g. v8 for linux/arm64/v8
+func ImageWithVariant(img v1.Image, variant string) (v1.Image, error) {
+	raw, err := img.RawConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+	cfg["variant"] = variant
+	rawConfig, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	m = m.DeepCopy()
+	m.Config.Digest, m.Config.Size, err = v1.SHA256(bytes.NewReader(rawConfig))
+	if err != nil {
+		return nil, err
+	}
+	rawManifest, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return &variantImage{
+		Image:       img,
+		rawConfig:   rawConfig,
+		manifest:    m,
+		rawManifest: rawManifest,
+	}, nil
+}
+
+func (i *variantImage) RawConfigFile() ([]byte, error) {
+	return i.rawConfig, nil
+}
+
+func (i *variantImage) ConfigFile() (*v1.ConfigFile, error) {
+	return v1.ParseConfigFile(bytes.NewReader(i.rawConfig))
+}
+
+func (i *variantImage) ConfigName() (v1.Hash, error) {
+	return i.manifest.Config.Digest, nil
+}
+
+func (i *variantImage) Manifest() (*v1.Manifest, error) {
+	return i.manifest.DeepCopy(), nil
+}
+
+func (i *variantImage) RawManifest() ([]byte, error) {
+	return i.rawManifest, nil
+}
+
+func (i *variantImage) Digest() (v1.Hash, error) {
+	h, _, err := v1.SHA256(bytes.NewReader(i.rawManifest))
+	return h, err
+}
+
+func (i *variantImage) Size() (int64, error) {
+	return int64(len(i.rawManifest)), nil
+}
@@ -18,12 +18,18 @@ package util
 
 import (
 	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/testutil"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
 )
@@ -105,3 +111,81 @@ func parse(s string) ([]instructions.Stage, error) {
 	}
 	return stages, err
 }
+
+func Test_remoteImage_Mirrors(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := func(status int, manifestRequests *int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v2/" {
+				return
+			}
+			*manifestRequests++
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			m, err := img.RawManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			mt, err := img.MediaType()
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Header().Set("Content-Type", string(mt))
+			w.Write(m)
+		}))
+	}
+	var originRequests, mirrorRequests int
+	origin := registry(http.StatusOK, &originRequests)
+	defer origin.Close()
+	// A failing mirror is skipped after a single attempt, even with retries
+	mirror := registry(http.StatusServiceUnavailable, &mirrorRequests)
+	defer mirror.Close()
+
+	originHost := strings.TrimPrefix(origin.URL, "http://")
+	mirrorHost := strings.TrimPrefix(mirror.URL, "http://")
+	opts := &config.KanikoOptions{
+		InsecurePull:    true,
+		PullRetry:       3,
+		RegistryMirrors: map[string][]string{originHost: {mirrorHost}},
+	}
+	actual, err := remoteImage(originHost+"/project/image:latest", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := actual.Digest()
+	testutil.CheckErrorAndDeepEqual(t, false, err, expected, digest)
+	testutil.CheckDeepEqual(t, 1, mirrorRequests)
+	testutil.CheckDeepEqual(t, 1, originRequests)
+}
+
+func Test_ImageWithVariant(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withVariant, err := ImageWithVariant(img, "v8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validate.Image(withVariant); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := withVariant.RawConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, "v8", cfg["variant"])
+}
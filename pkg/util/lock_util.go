@@ -0,0 +1,118 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// BaseImageLock is the content of the --base-image-lock file, which records the digest
+// each base image resolved to, keyed by image reference
+type BaseImageLock struct {
+	Images map[string]string `json:"images"`
+}
+
+var baseImageLockMu sync.Mutex
+
+// ReadBaseImageLock reads the lock file at path, which may not exist yet
+func ReadBaseImageLock(path string) (*BaseImageLock, error) {
+	lock := &BaseImageLock{Images: map[string]string{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading base image lock")
+	}
+	if err := json.Unmarshal(b, lock); err != nil {
+		return nil, errors.Wrapf(err, "parsing base image lock %s", path)
+	}
+	if lock.Images == nil {
+		lock.Images = map[string]string{}
+	}
+	return lock, nil
+}
+
+func (l *BaseImageLock) write(path string) error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// RetrieveLockedImage retrieves image using retrieve, and checks its digest against the --base-image-lock file.
+// Images missing from the lock are added to it. If the digest drifted from the locked one, the build fails,
+// or in warn mode the locked digest is used instead, or in update mode the lock is updated.
+func RetrieveLockedImage(image string, opts *config.KanikoOptions, retrieve func(string, *config.KanikoOptions) (v1.Image, error)) (v1.Image, error) {
+	if opts.BaseImageLock == "" {
+		return retrieve(image, opts)
+	}
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	// Images referenced by digest are already pinned.
+	if _, ok := ref.(name.Digest); ok {
+		return retrieve(image, opts)
+	}
+
+	img, err := retrieve(image, opts)
+	if err != nil {
+		return nil, err
+	}
+	d, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	baseImageLockMu.Lock()
+	defer baseImageLockMu.Unlock()
+	lock, err := ReadBaseImageLock(opts.BaseImageLock)
+	if err != nil {
+		return nil, err
+	}
+	locked, ok := lock.Images[image]
+	switch {
+	case locked == d.String():
+		logrus.Infof("Image %s matches locked digest %s", image, locked)
+		return img, nil
+	case !ok || opts.BaseImageLockMode == constants.BaseImageLockModeUpdate:
+		logrus.Infof("Locking image %s to digest %s", image, d)
+		lock.Images[image] = d.String()
+		if err := lock.write(opts.BaseImageLock); err != nil {
+			return nil, errors.Wrap(err, "writing base image lock")
+		}
+		return img, nil
+	case opts.BaseImageLockMode == constants.BaseImageLockModeWarn:
+		logrus.Warnf("Image %s resolved to %s instead of locked digest %s, using the locked digest", image, d, locked)
+		return retrieve(ref.Context().Name()+"@"+locked, opts)
+	default:
+		return nil, fmt.Errorf("image %s resolved to %s instead of digest %s locked in %s", image, d, locked, opts.BaseImageLock)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func Test_RetrieveLockedImage(t *testing.T) {
+	oldImage, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newImage, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDigest, err := oldImage.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDigest, err := newImage.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The registry serves newImage for the tag, and oldImage by its digest.
+	retrieve := func(image string, opts *config.KanikoOptions) (v1.Image, error) {
+		if image == "index.docker.io/library/golang@"+oldDigest.String() {
+			return oldImage, nil
+		}
+		return newImage, nil
+	}
+
+	tests := []struct {
+		name           string
+		mode           string
+		locked         string
+		expectedImage  v1.Image
+		expectedLocked string
+		shouldError    bool
+	}{
+		{
+			name:           "not locked yet",
+			mode:           constants.BaseImageLockModeStrict,
+			expectedImage:  newImage,
+			expectedLocked: newDigest.String(),
+		},
+		{
+			name:           "matches lock",
+			mode:           constants.BaseImageLockModeStrict,
+			locked:         newDigest.String(),
+			expectedImage:  newImage,
+			expectedLocked: newDigest.String(),
+		},
+		{
+			name:           "drifted in strict mode",
+			mode:           constants.BaseImageLockModeStrict,
+			locked:         oldDigest.String(),
+			expectedLocked: oldDigest.String(),
+			shouldError:    true,
+		},
+		{
+			name:           "drifted in warn mode",
+			mode:           constants.BaseImageLockModeWarn,
+			locked:         oldDigest.String(),
+			expectedImage:  oldImage,
+			expectedLocked: oldDigest.String(),
+		},
+		{
+			name:           "drifted in update mode",
+			mode:           constants.BaseImageLockModeUpdate,
+			locked:         oldDigest.String(),
+			expectedImage:  newImage,
+			expectedLocked: newDigest.String(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "lock-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "lock.json")
+			if test.locked != "" {
+				lock := &BaseImageLock{Images: map[string]string{"golang:1.13": test.locked}}
+				if err := lock.write(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opts := &config.KanikoOptions{BaseImageLock: path, BaseImageLockMode: test.mode}
+			img, err := RetrieveLockedImage("golang:1.13", opts, retrieve)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldError, err, test.expectedImage, img)
+
+			lock, err := ReadBaseImageLock(path)
+			testutil.CheckErrorAndDeepEqual(t, false, err, map[string]string{"golang:1.13": test.expectedLocked}, lock.Images)
+		})
+	}
+}
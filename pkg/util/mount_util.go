@@ -0,0 +1,141 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// layerOrigins are references to images in the repositories each layer is known to exist in, keyed by layer digest
+	layerOrigins   = map[v1.Hash][]name.Reference{}
+	layerOriginsMu sync.Mutex
+)
+
+// ResetLayerOrigins forgets the layer origins recorded by a previous build
+func ResetLayerOrigins() {
+	layerOriginsMu.Lock()
+	defer layerOriginsMu.Unlock()
+	layerOrigins = map[v1.Hash][]name.Reference{}
+}
+
+// RecordLayerOrigins remembers that the layers of img exist in the repository of ref,
+// so they can be mounted from there rather than uploaded when pushing to the same registry
+func RecordLayerOrigins(img v1.Image, ref name.Reference) error {
+	m, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	layerOriginsMu.Lock()
+	defer layerOriginsMu.Unlock()
+	for _, desc := range m.Layers {
+		if !containsRepository(layerOrigins[desc.Digest], ref.Context()) {
+			layerOrigins[desc.Digest] = append(layerOrigins[desc.Digest], ref)
+		}
+	}
+	return nil
+}
+
+func containsRepository(refs []name.Reference, repo name.Repository) bool {
+	for _, r := range refs {
+		if r.Context().Name() == repo.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// layerOrigin returns an image in another repository of the registry of dest
+// which the layer with digest h is known to exist in
+func layerOrigin(h v1.Hash, dest name.Reference) (name.Reference, bool) {
+	layerOriginsMu.Lock()
+	defer layerOriginsMu.Unlock()
+	for _, ref := range layerOrigins[h] {
+		if ref.Context().RegistryStr() == dest.Context().RegistryStr() && ref.Context().Name() != dest.Context().Name() {
+			return ref, true
+		}
+	}
+	return nil, false
+}
+
+// MountableImage wraps img so that pushing it to dest mounts the layers which exist in another repository
+// of the same registry, as recorded by RecordLayerOrigins, instead of uploading them
+func MountableImage(img v1.Image, dest name.Reference) v1.Image {
+	return &mountableImage{Image: img, dest: dest}
+}
+
+type mountableImage struct {
+	v1.Image
+	dest name.Reference
+}
+
+// Layers implements v1.Image
+func (mi *mountableImage) Layers() ([]v1.Layer, error) {
+	ls, err := mi.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	mls := make([]v1.Layer, 0, len(ls))
+	for _, l := range ls {
+		mls = append(mls, mi.mountable(l))
+	}
+	return mls, nil
+}
+
+// LayerByDigest implements v1.Image
+func (mi *mountableImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	l, err := mi.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	return mi.mountable(l), nil
+}
+
+// LayerByDiffID implements v1.Image
+func (mi *mountableImage) LayerByDiffID(h v1.Hash) (v1.Layer, error) {
+	l, err := mi.Image.LayerByDiffID(h)
+	if err != nil {
+		return nil, err
+	}
+	return mi.mountable(l), nil
+}
+
+// mountable wraps l in a remote.MountableLayer if it is known to exist in the registry of mi.dest
+func (mi *mountableImage) mountable(l v1.Layer) v1.Layer {
+	if ml, ok := l.(*remote.MountableLayer); ok {
+		// Layers of images pulled with remote.Image can already be mounted from there
+		if ml.Reference.Context().RegistryStr() == mi.dest.Context().RegistryStr() {
+			return l
+		}
+		l = ml.Layer
+	}
+	h, err := l.Digest()
+	if err != nil {
+		return l
+	}
+	origin, ok := layerOrigin(h, mi.dest)
+	if !ok {
+		return l
+	}
+	logrus.Debugf("Mounting layer %s from %s", h, origin.Context())
+	return &remote.MountableLayer{Layer: l, Reference: origin}
+}
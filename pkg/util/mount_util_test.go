@@ -0,0 +1,174 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func Test_MountableImage(t *testing.T) {
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := other.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.AppendLayers(base, layers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ResetLayerOrigins()
+	defer ResetLayerOrigins()
+	baseRef, err := name.ParseReference("gcr.io/project/base:latest", name.WeakValidation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordLayerOrigins(base, baseRef); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		dest        string
+		mounted     []string
+	}{
+		{
+			description: "same registry",
+			dest:        "gcr.io/project/app:latest",
+			mounted:     []string{"gcr.io/project/base", "gcr.io/project/base", ""},
+		},
+		{
+			description: "same repository",
+			dest:        "gcr.io/project/base:v2",
+			mounted:     []string{"", "", ""},
+		},
+		{
+			description: "other registry",
+			dest:        "docker.io/project/app:latest",
+			mounted:     []string{"", "", ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			dest, err := name.ParseReference(test.dest, name.WeakValidation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			layers, err := MountableImage(img, dest).Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mounted []string
+			for _, l := range layers {
+				origin := ""
+				if ml, ok := l.(*remote.MountableLayer); ok {
+					origin = ml.Reference.Context().Name()
+				}
+				mounted = append(mounted, origin)
+			}
+			testutil.CheckDeepEqual(t, test.mounted, mounted)
+		})
+	}
+}
+
+func Test_MountableImage_Layer(t *testing.T) {
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffID, err := layers[0].DiffID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := layers[1].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ResetLayerOrigins()
+	defer ResetLayerOrigins()
+	baseRef, err := name.ParseReference("gcr.io/project/base:latest", name.WeakValidation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordLayerOrigins(img, baseRef); err != nil {
+		t.Fatal(err)
+	}
+	dest, err := name.ParseReference("gcr.io/project/app:latest", name.WeakValidation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := MountableImage(img, dest)
+
+	byDiffID, err := mi.LayerByDiffID(diffID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byDigest, err := mi.LayerByDigest(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []v1.Layer{byDiffID, byDigest} {
+		ml, ok := l.(*remote.MountableLayer)
+		if !ok {
+			t.Fatalf("expected %v to be mountable", l)
+		}
+		testutil.CheckDeepEqual(t, "gcr.io/project/base", ml.Reference.Context().Name())
+	}
+
+	// Layers already mountable from the registry of dest are left as they are
+	otherRef, err := name.ParseReference("gcr.io/project/other:latest", name.WeakValidation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml := &remote.MountableLayer{Layer: layers[0], Reference: otherRef}
+	if l := mi.(*mountableImage).mountable(ml); l != v1.Layer(ml) {
+		t.Errorf("expected %v to be left as it is, got %v", ml, l)
+	}
+
+	// but not when they can only be mounted from another registry
+	mirrorRef, err := name.ParseReference("mirror.gcr.io/project/base:latest", name.WeakValidation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounted, ok := mi.(*mountableImage).mountable(&remote.MountableLayer{Layer: layers[0], Reference: mirrorRef}).(*remote.MountableLayer)
+	if !ok {
+		t.Fatal("expected the layer to be mountable")
+	}
+	testutil.CheckDeepEqual(t, "gcr.io/project/base", mounted.Reference.Context().Name())
+	if mounted.Layer != layers[0] {
+		t.Errorf("expected the layer not to be wrapped twice, got %v", mounted.Layer)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ImageRewriteRule rewrites image references which start with Prefix, or match Regex,
+// using Replacement. Regex replacements may refer to submatches, e.g. $1.
+type ImageRewriteRule struct {
+	Prefix      string `json:"prefix,omitempty"`
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement"`
+
+	re *regexp.Regexp
+}
+
+// ImageRewrite records an image reference rewritten by an ImageRewriteRule,
+// and the digest of the image that was used
+type ImageRewrite struct {
+	Original  string `json:"original"`
+	Rewritten string `json:"rewritten"`
+	Digest    string `json:"digest"`
+}
+
+var (
+	// imageRewriteRules caches the rules loaded from each --image-rewrite-rules file,
+	// and imageRewrites the rewrites made during the current build
+	imageRewriteRules = map[string][]ImageRewriteRule{}
+	imageRewrites     []ImageRewrite
+	imageRewritesMu   sync.Mutex
+)
+
+// ResetImageRewrites forgets the rules loaded and the image rewrites recorded by a previous build
+func ResetImageRewrites() {
+	imageRewritesMu.Lock()
+	defer imageRewritesMu.Unlock()
+	imageRewriteRules = map[string][]ImageRewriteRule{}
+	imageRewrites = nil
+}
+
+// LoadImageRewriteRules reads the JSON list of rules in the file at path
+func LoadImageRewriteRules(path string) ([]ImageRewriteRule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading image rewrite rules")
+	}
+	var rules []ImageRewriteRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, errors.Wrapf(err, "parsing image rewrite rules %s", path)
+	}
+	for i, rule := range rules {
+		if (rule.Prefix == "") == (rule.Regex == "") {
+			return nil, fmt.Errorf("image rewrite rule %d must set exactly one of prefix and regex", i)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, errors.Wrapf(err, "compiling image rewrite rule %d", i)
+			}
+			rules[i].re = re
+		}
+	}
+	return rules, nil
+}
+
+// RewriteImage returns image rewritten by the first of rules which matches it,
+// and false if none of them match
+func RewriteImage(image string, rules []ImageRewriteRule) (string, bool) {
+	for _, rule := range rules {
+		if rule.re != nil {
+			if rule.re.MatchString(image) {
+				return rule.re.ReplaceAllString(image, rule.Replacement), true
+			}
+		} else if strings.HasPrefix(image, rule.Prefix) {
+			return rule.Replacement + strings.TrimPrefix(image, rule.Prefix), true
+		}
+	}
+	return image, false
+}
+
+// ApplyImageRewriteRules rewrites image using the rules in the --image-rewrite-rules file, if set
+func ApplyImageRewriteRules(image string, opts *config.KanikoOptions) (string, error) {
+	if opts.ImageRewriteRules == "" {
+		return image, nil
+	}
+	rules, err := cachedImageRewriteRules(opts.ImageRewriteRules)
+	if err != nil {
+		return "", err
+	}
+	rewritten, ok := RewriteImage(image, rules)
+	if ok {
+		logrus.Infof("Rewrote image %s to %s", image, rewritten)
+	}
+	return rewritten, nil
+}
+
+// cachedImageRewriteRules loads the rules in the file at path the first time they are needed
+func cachedImageRewriteRules(path string) ([]ImageRewriteRule, error) {
+	imageRewritesMu.Lock()
+	defer imageRewritesMu.Unlock()
+	if rules, ok := imageRewriteRules[path]; ok {
+		return rules, nil
+	}
+	rules, err := LoadImageRewriteRules(path)
+	if err != nil {
+		return nil, err
+	}
+	imageRewriteRules[path] = rules
+	return rules, nil
+}
+
+// RecordImageRewrite remembers that original was rewritten to the image img, if they differ
+func RecordImageRewrite(original, rewritten string, img v1.Image) error {
+	if original == rewritten {
+		return nil
+	}
+	d, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	rewrite := ImageRewrite{
+		Original:  original,
+		Rewritten: rewritten,
+		Digest:    d.String(),
+	}
+	imageRewritesMu.Lock()
+	defer imageRewritesMu.Unlock()
+	// The same image may be retrieved for several stages
+	for _, r := range imageRewrites {
+		if r == rewrite {
+			return nil
+		}
+	}
+	logrus.Infof("Using %s@%s for %s", rewritten, d, original)
+	imageRewrites = append(imageRewrites, rewrite)
+	return nil
+}
+
+// ImageRewrites returns the image references rewritten so far
+func ImageRewrites() []ImageRewrite {
+	imageRewritesMu.Lock()
+	defer imageRewritesMu.Unlock()
+	return append([]ImageRewrite{}, imageRewrites...)
+}
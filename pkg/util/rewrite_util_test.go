@@ -0,0 +1,140 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+)
+
+func writeRewriteRules(t *testing.T, rules string) string {
+	dir, err := ioutil.TempDir("", "rewrite-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "rules.json")
+	if err := ioutil.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_LoadImageRewriteRules(t *testing.T) {
+	tests := []struct {
+		name        string
+		rules       string
+		shouldError bool
+	}{
+		{name: "valid", rules: `[{"prefix": "golang:", "replacement": "internal/golang:"}, {"regex": "^node:(.*)$", "replacement": "internal/node:$1"}]`},
+		{name: "invalid json", rules: `{`, shouldError: true},
+		{name: "neither prefix nor regex", rules: `[{"replacement": "foo"}]`, shouldError: true},
+		{name: "both prefix and regex", rules: `[{"prefix": "a", "regex": "b", "replacement": "c"}]`, shouldError: true},
+		{name: "invalid regex", rules: `[{"regex": "(", "replacement": "c"}]`, shouldError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeRewriteRules(t, test.rules)
+			defer os.RemoveAll(filepath.Dir(path))
+			_, err := LoadImageRewriteRules(path)
+			testutil.CheckError(t, test.shouldError, err)
+		})
+	}
+}
+
+func Test_RewriteImage(t *testing.T) {
+	path := writeRewriteRules(t, `[
+	{"prefix": "golang:", "replacement": "registry.internal/hardened/golang:"},
+	{"regex": "^(docker.io/)?(library/)?node:(.*)$", "replacement": "registry.internal/node:$3"},
+	{"prefix": "golang", "replacement": "unused"}
+]`)
+	defer os.RemoveAll(filepath.Dir(path))
+	rules, err := LoadImageRewriteRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		image     string
+		expected  string
+		rewritten bool
+	}{
+		{image: "golang:1.13", expected: "registry.internal/hardened/golang:1.13", rewritten: true},
+		{image: "docker.io/library/node:12", expected: "registry.internal/node:12", rewritten: true},
+		{image: "node:12-alpine", expected: "registry.internal/node:12-alpine", rewritten: true},
+		{image: "ubuntu:18.04", expected: "ubuntu:18.04"},
+	}
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			actual, ok := RewriteImage(test.image, rules)
+			testutil.CheckDeepEqual(t, test.expected, actual)
+			testutil.CheckDeepEqual(t, test.rewritten, ok)
+		})
+	}
+}
+
+func Test_RetrieveSourceImage_Rewrite(t *testing.T) {
+	path := writeRewriteRules(t, `[{"prefix": "gcr.io/distroless/", "replacement": "registry.internal/distroless/"}]`)
+	defer os.RemoveAll(filepath.Dir(path))
+	stages, err := parse(dockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := RetrieveRemoteImage
+	defer func() {
+		RetrieveRemoteImage = original
+		ResetImageRewrites()
+	}()
+	var retrieved string
+	RetrieveRemoteImage = func(image string, opts *config.KanikoOptions) (v1.Image, error) {
+		retrieved = image
+		return empty.Image, nil
+	}
+	opts := &config.KanikoOptions{ImageRewriteRules: path}
+	_, err = RetrieveSourceImage(config.KanikoStage{
+		Stage: stages[0],
+	}, opts)
+	testutil.CheckErrorAndDeepEqual(t, false, err, "registry.internal/distroless/base:latest", retrieved)
+
+	// The rules are only read once, and retrieving the image again is only recorded once
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	retrieved = ""
+	_, err = RetrieveSourceImage(config.KanikoStage{
+		Stage: stages[0],
+	}, opts)
+	testutil.CheckErrorAndDeepEqual(t, false, err, "registry.internal/distroless/base:latest", retrieved)
+
+	d, err := empty.Image.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, []ImageRewrite{{
+		Original:  "gcr.io/distroless/base:latest",
+		Rewritten: "registry.internal/distroless/base:latest",
+		Digest:    d.String(),
+	}}, ImageRewrites())
+
+	ResetImageRewrites()
+	testutil.CheckDeepEqual(t, []ImageRewrite{}, ImageRewrites())
+}
@@ -27,7 +27,9 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -37,6 +39,8 @@ import (
 type Tar struct {
 	hardlinks map[uint64]string
 	w         *tar.Writer
+	// maxModTime is the latest modification time written, if set
+	maxModTime time.Time
 }
 
 // NewTar will create an instance of Tar that can write files to the writer at f.
@@ -53,6 +57,12 @@ func (t *Tar) Close() {
 	t.w.Close()
 }
 
+// ClampModTimes makes the modification times of the files added later than max be max in the tar,
+// like tar --clamp-mtime does with SOURCE_DATE_EPOCH
+func (t *Tar) ClampModTimes(max time.Time) {
+	t.maxModTime = max
+}
+
 // AddFileToTar adds the file at path p to the tar
 func (t *Tar) AddFileToTar(p string) error {
 	i, err := os.Lstat(p)
@@ -67,6 +77,7 @@ func (t *Tar) AddFileToTar(p string) error {
 			return err
 		}
 	}
+	// Sockets only exist while a process listens on them, and tar has no type for them
 	if i.Mode()&os.ModeSocket != 0 {
 		logrus.Infof("ignoring socket %s, not adding to tar", i.Name())
 		return nil
@@ -84,11 +95,21 @@ func (t *Tar) AddFileToTar(p string) error {
 		hdr.Name = p
 	}
 
+	if !t.maxModTime.IsZero() {
+		if hdr.ModTime.After(t.maxModTime) {
+			hdr.ModTime = t.maxModTime
+		}
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+	}
+
 	hardlink, linkDst := t.checkHardlink(p, i)
 	if hardlink {
 		hdr.Linkname = linkDst
 		hdr.Typeflag = tar.TypeLink
 		hdr.Size = 0
+	} else if err := addXattrsToHeader(hdr, p, i); err != nil {
+		return err
 	}
 	if err := t.w.WriteHeader(hdr); err != nil {
 		return err
@@ -101,15 +122,71 @@ func (t *Tar) AddFileToTar(p string) error {
 		return err
 	}
 	defer r.Close()
-	if _, err := io.Copy(t.w, r); err != nil {
+	// archive/tar can't write sparse entries, so holes are written as zeros, which compress well.
+	// They aren't read from disk though.
+	var contents io.Reader = r
+	if segments := dataSegments(r, hdr.Size); segments != nil {
+		logrus.Debugf("Expanding the holes of sparse file %s", p)
+		contents = &sparseReader{f: r, segments: segments, size: hdr.Size}
+	}
+	if _, err := io.Copy(t.w, contents); err != nil {
 		return err
 	}
 	return nil
 }
 
+// sparseReader reads a file with holes, only reading its data segments from disk
+type sparseReader struct {
+	f        *os.File
+	segments [][2]int64
+	size     int64
+	offset   int64
+}
+
+func (s *sparseReader) Read(b []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > s.size-s.offset {
+		b = b[:s.size-s.offset]
+	}
+	for len(s.segments) > 0 && s.segments[0][0]+s.segments[0][1] <= s.offset {
+		s.segments = s.segments[1:]
+	}
+	if len(s.segments) == 0 || s.offset < s.segments[0][0] {
+		// In a hole, up to the next segment
+		end := s.size
+		if len(s.segments) > 0 {
+			end = s.segments[0][0]
+		}
+		if int64(len(b)) > end-s.offset {
+			b = b[:end-s.offset]
+		}
+		for i := range b {
+			b[i] = 0
+		}
+		s.offset += int64(len(b))
+		return len(b), nil
+	}
+	end := s.segments[0][0] + s.segments[0][1]
+	if int64(len(b)) > end-s.offset {
+		b = b[:end-s.offset]
+	}
+	n, err := s.f.ReadAt(b, s.offset)
+	s.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	if err == io.EOF {
+		// The file was truncated while reading it
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
 func (t *Tar) Whiteout(p string) error {
 	dir := filepath.Dir(p)
-	name := ".wh." + filepath.Base(p)
+	name := constants.WhiteoutPrefix + filepath.Base(p)
 
 	th := &tar.Header{
 		// Docker uses no leading / in the tarball
@@ -123,6 +200,17 @@ func (t *Tar) Whiteout(p string) error {
 	return nil
 }
 
+// OpaqueWhiteout marks that the contents of dir in lower layers are hidden,
+// so that only the ones in this layer are in the directory
+func (t *Tar) OpaqueWhiteout(dir string) error {
+	th := &tar.Header{
+		// Docker uses no leading / in the tarball
+		Name: strings.TrimLeft(filepath.Join(dir, constants.OpaqueWhiteout), "/"),
+		Size: 0,
+	}
+	return t.w.WriteHeader(th)
+}
+
 // Returns true if path is hardlink, and the link destination
 func (t *Tar) checkHardlink(p string, i os.FileInfo) (bool, string) {
 	hardlink := false
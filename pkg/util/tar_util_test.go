@@ -17,12 +17,15 @@ limitations under the License.
 package util
 
 import (
+	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/GoogleContainerTools/kaniko/testutil"
 )
@@ -101,3 +104,54 @@ func createTar(testdir string, writer io.Writer) error {
 	}
 	return nil
 }
+
+func TestClampModTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	epoch := time.Unix(1577836800, 0).UTC()
+	mtimes := map[string]time.Time{
+		"old": epoch.Add(-time.Hour),
+		"new": epoch.Add(time.Hour),
+	}
+	for name, mtime := range mtimes {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	tw := NewTar(&buf)
+	tw.ClampModTimes(epoch)
+	for _, name := range []string{"new", "old"} {
+		if err := tw.AddFileToTar(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+
+	expected := map[string]time.Time{
+		"new": epoch,
+		"old": mtimes["old"],
+	}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Base(hdr.Name)
+		if !hdr.ModTime.Equal(expected[name]) {
+			t.Errorf("Expected the modification time of %s to be %s, got %s", name, expected[name], hdr.ModTime)
+		}
+	}
+}
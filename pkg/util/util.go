@@ -20,12 +20,18 @@ import (
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
+	"math"
+	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/minio/highwayhash"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -65,6 +71,9 @@ func Hasher() func(string) (string, error) {
 		h.Write([]byte(strconv.FormatUint(uint64(fi.Sys().(*syscall.Stat_t).Uid), 36)))
 		h.Write([]byte(","))
 		h.Write([]byte(strconv.FormatUint(uint64(fi.Sys().(*syscall.Stat_t).Gid), 36)))
+		if err := hashXattrs(h, p, fi); err != nil {
+			return "", err
+		}
 
 		if fi.Mode().IsRegular() {
 			f, err := os.Open(p)
@@ -97,6 +106,9 @@ func CacheHasher() func(string) (string, error) {
 		h.Write([]byte(strconv.FormatUint(uint64(fi.Sys().(*syscall.Stat_t).Uid), 36)))
 		h.Write([]byte(","))
 		h.Write([]byte(strconv.FormatUint(uint64(fi.Sys().(*syscall.Stat_t).Gid), 36)))
+		if err := hashXattrs(h, p, fi); err != nil {
+			return "", err
+		}
 
 		if fi.Mode().IsRegular() {
 			f, err := os.Open(p)
@@ -114,7 +126,8 @@ func CacheHasher() func(string) (string, error) {
 	return hasher
 }
 
-// MtimeHasher returns a hash function, which only looks at mtime to determine if a file has changed.
+// MtimeHasher returns a hash function, which only looks at mtime and the xattrs added to layers to determine
+// if a file has changed, as setting an xattr doesn't change the mtime.
 // Note that the mtime can lag, so it's possible that a file will have changed but the mtime may look the same.
 func MtimeHasher() func(string) (string, error) {
 	hasher := func(p string) (string, error) {
@@ -124,11 +137,55 @@ func MtimeHasher() func(string) (string, error) {
 			return "", err
 		}
 		h.Write([]byte(fi.ModTime().String()))
+		if err := hashXattrs(h, p, fi); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	return hasher
+}
+
+// RedoHasher returns a hash function which looks at a file's metadata (mode, size, mtime, uid/gid, inode and
+// the xattrs added to layers) but not its contents. Package managers replace files or at least change their size or mtime,
+// so this is almost as reliable as Hasher while being almost as cheap as MtimeHasher.
+func RedoHasher() func(string) (string, error) {
+	hasher := func(p string) (string, error) {
+		h := md5.New()
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return "", err
+		}
+		stat := fi.Sys().(*syscall.Stat_t)
+		h.Write([]byte(fi.Mode().String()))
+		h.Write([]byte(fi.ModTime().String()))
+		h.Write([]byte(strconv.FormatInt(fi.Size(), 36)))
+		h.Write([]byte(","))
+		h.Write([]byte(strconv.FormatUint(uint64(stat.Uid), 36)))
+		h.Write([]byte(","))
+		h.Write([]byte(strconv.FormatUint(uint64(stat.Gid), 36)))
+		h.Write([]byte(","))
+		h.Write([]byte(strconv.FormatUint(uint64(stat.Ino), 36)))
+		if err := hashXattrs(h, p, fi); err != nil {
+			return "", err
+		}
+
 		return hex.EncodeToString(h.Sum(nil)), nil
 	}
 	return hasher
 }
 
+// ParseSourceDateEpoch parses a SOURCE_DATE_EPOCH, the number of seconds since the Unix epoch
+func ParseSourceDateEpoch(s string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "parsing source date epoch %s", s)
+	}
+	if seconds < 0 {
+		return time.Time{}, errors.Errorf("source date epoch %s is negative", s)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
 // SHA256 returns the shasum of the contents of r
 func SHA256(r io.Reader) (string, error) {
 	hasher := sha256.New()
@@ -138,3 +195,51 @@ func SHA256(r io.Reader) (string, error) {
 	}
 	return hex.EncodeToString(hasher.Sum(make([]byte, 0, hasher.Size()))), nil
 }
+
+// Retry retries an operation up to retryCount times, doubling the delay between attempts
+// starting at initialDelayMilliseconds
+func Retry(operation func() error, retryCount int, initialDelayMilliseconds int) error {
+	return retry(operation, func(error) bool { return true }, retryCount, initialDelayMilliseconds)
+}
+
+// RetryTransient is like Retry, but only retries errors which IsTransientError considers temporary,
+// so that e.g. missing images or denied requests fail right away
+func RetryTransient(operation func() error, retryCount int, initialDelayMilliseconds int) error {
+	return retry(operation, IsTransientError, retryCount, initialDelayMilliseconds)
+}
+
+func retry(operation func() error, retryable func(error) bool, retryCount int, initialDelayMilliseconds int) error {
+	err := operation()
+	for i := 0; err != nil && retryable(err) && i < retryCount; i++ {
+		sleepDuration := time.Millisecond * time.Duration(int(math.Pow(2, float64(i)))*initialDelayMilliseconds)
+		logrus.Warnf("Retrying operation after %s (retry %d of %d) due to %v", sleepDuration, i+1, retryCount, err)
+		time.Sleep(sleepDuration)
+		err = operation()
+	}
+	return err
+}
+
+// StatusError is an unexpected status of an HTTP response which isn't from a registry
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %s", e.Status)
+}
+
+// IsTransientError returns true if err may go away when retrying: network errors,
+// and registry or HTTP responses with a 5xx or 429 status or a temporary error code
+func IsTransientError(err error) bool {
+	cause := errors.Cause(err)
+	switch e := cause.(type) {
+	case *transport.Error:
+		return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests || e.Temporary()
+	case *StatusError:
+		return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+	case net.Error:
+		return true
+	}
+	return cause == io.ErrUnexpectedEOF || cause == syscall.ECONNRESET || cause == syscall.ECONNREFUSED
+}
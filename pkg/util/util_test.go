@@ -0,0 +1,256 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		description string
+		failures    int
+		retryCount  int
+		shouldErr   bool
+		calls       int
+	}{
+		{
+			description: "succeeds on first attempt",
+			failures:    0,
+			retryCount:  3,
+			calls:       1,
+		},
+		{
+			description: "succeeds after retries",
+			failures:    2,
+			retryCount:  3,
+			calls:       3,
+		},
+		{
+			description: "gives up after retry count",
+			failures:    5,
+			retryCount:  2,
+			shouldErr:   true,
+			calls:       3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			calls := 0
+			err := Retry(func() error {
+				calls++
+				if calls <= test.failures {
+					return errors.New("transient error")
+				}
+				return nil
+			}, test.retryCount, 0)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.calls, calls)
+		})
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	tests := []struct {
+		description string
+		err         error
+		calls       int
+	}{
+		{
+			description: "retries transient errors",
+			err:         &transport.Error{StatusCode: http.StatusServiceUnavailable},
+			calls:       3,
+		},
+		{
+			description: "does not retry other errors",
+			err:         &transport.Error{StatusCode: http.StatusNotFound},
+			calls:       1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			calls := 0
+			err := RetryTransient(func() error {
+				calls++
+				return test.err
+			}, 2, 0)
+			testutil.CheckErrorAndDeepEqual(t, true, err, test.calls, calls)
+		})
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		description string
+		err         error
+		expected    bool
+	}{
+		{
+			description: "server error",
+			err:         &transport.Error{StatusCode: http.StatusBadGateway},
+			expected:    true,
+		},
+		{
+			description: "too many requests",
+			err:         &transport.Error{StatusCode: http.StatusTooManyRequests},
+			expected:    true,
+		},
+		{
+			description: "temporary registry error",
+			err: &transport.Error{
+				StatusCode: http.StatusBadRequest,
+				Errors:     []transport.Diagnostic{{Code: transport.BlobUploadInvalidErrorCode}},
+			},
+			expected: true,
+		},
+		{
+			description: "unauthorized",
+			err:         &transport.Error{StatusCode: http.StatusUnauthorized},
+		},
+		{
+			description: "http server error",
+			err:         &StatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"},
+			expected:    true,
+		},
+		{
+			description: "http not found",
+			err:         &StatusError{StatusCode: http.StatusNotFound, Status: "404 Not Found"},
+		},
+		{
+			description: "network error",
+			err:         &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED},
+			expected:    true,
+		},
+		{
+			description: "wrapped connection reset",
+			err:         pkgerrors.Wrap(syscall.ECONNRESET, "pushing"),
+			expected:    true,
+		},
+		{
+			description: "unexpected EOF",
+			err:         io.ErrUnexpectedEOF,
+			expected:    true,
+		},
+		{
+			description: "other error",
+			err:         errors.New("cache entry expired"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			testutil.CheckDeepEqual(t, test.expected, IsTransientError(test.err))
+		})
+	}
+}
+
+func TestRedoHasher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	mtime := time.Unix(1000, 0)
+	write := func(contents string) {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hasher := RedoHasher()
+	hash := func() string {
+		h, err := hasher(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	write("contents")
+	original := hash()
+
+	// Contents of the same size written in place with the same mtime aren't detected.
+	write("CONTENTS")
+	testutil.CheckDeepEqual(t, original, hash())
+
+	write("longer contents")
+	resized := hash()
+	if resized == original {
+		t.Error("expected a different hash when the size changes")
+	}
+
+	if err := os.Chmod(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if hash() == resized {
+		t.Error("expected a different hash when the mode changes")
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing the file, like package managers do, changes its inode.
+	replacement := filepath.Join(dir, "replacement")
+	if err := ioutil.WriteFile(replacement, []byte("longer contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(replacement, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(replacement, path); err != nil {
+		t.Fatal(err)
+	}
+	if hash() == resized {
+		t.Error("expected a different hash when the file is replaced")
+	}
+
+	_, err = hasher(filepath.Join(dir, "missing"))
+	testutil.CheckError(t, true, err)
+}
+
+func TestParseSourceDateEpoch(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  time.Time
+		shouldErr bool
+	}{
+		{value: "0", expected: time.Unix(0, 0).UTC()},
+		{value: "1577836800", expected: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "-1", shouldErr: true},
+		{value: "yesterday", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			epoch, err := ParseSourceDateEpoch(test.value)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, epoch)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// paxXattrPrefix is the prefix of the PAX records holding extended attributes, as written by GNU tar and docker
+const paxXattrPrefix = "SCHILY.xattr."
+
+// preservedXattrs are the extended attributes (or namespaces, if they end in a dot) which are added to layers.
+// Others, like security.selinux or trusted.overlay.opaque, describe the host rather than the image.
+var preservedXattrs = []string{
+	"user.",
+	"security.capability",
+	"system.posix_acl_access",
+	"system.posix_acl_default",
+}
+
+func isPreservedXattr(name string) bool {
+	for _, preserved := range preservedXattrs {
+		if name == preserved || (strings.HasSuffix(preserved, ".") && strings.HasPrefix(name, preserved)) {
+			return true
+		}
+	}
+	return false
+}
+
+// PreservedXattrs returns the extended attributes of p which are added to layers
+func PreservedXattrs(p string, fi os.FileInfo) (map[string][]byte, error) {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return nil, nil
+	}
+	xattrs, err := Xattrs(p)
+	if err != nil {
+		return nil, err
+	}
+	for name := range xattrs {
+		if !isPreservedXattr(name) {
+			delete(xattrs, name)
+		}
+	}
+	return xattrs, nil
+}
+
+// hashXattrs writes the extended attributes of p which are added to layers to h, in a stable order
+func hashXattrs(h io.Writer, p string, fi os.FileInfo) error {
+	xattrs, err := PreservedXattrs(p, fi)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write(xattrs[name])
+		h.Write([]byte{0})
+	}
+	return nil
+}
+
+// addXattrsToHeader records the extended attributes of p which are added to layers in hdr's PAX records
+func addXattrsToHeader(hdr *tar.Header, p string, fi os.FileInfo) error {
+	xattrs, err := PreservedXattrs(p, fi)
+	if err != nil {
+		return err
+	}
+	for name, value := range xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[paxXattrPrefix+name] = string(value)
+	}
+	return nil
+}
+
+// setXattrsFromHeader sets the extended attributes recorded in hdr's PAX records on path.
+// Attributes the filesystem doesn't support or kaniko isn't allowed to set are skipped with a warning.
+func setXattrsFromHeader(path string, hdr *tar.Header) error {
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, paxXattrPrefix)
+		if !isPreservedXattr(name) {
+			logrus.Debugf("Not setting xattr %s on %s", name, path)
+			continue
+		}
+		err := setXattr(path, name, []byte(value))
+		if err == syscall.ENOTSUP || err == syscall.EPERM {
+			logrus.Warnf("Unable to set xattr %s on %s: %s", name, path, err)
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "setting xattr %s on %s", name, path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Xattrs returns the extended attributes of path, or nil if the filesystem doesn't support them.
+// Symlinks are followed, so callers should skip them: the kernel doesn't allow user attributes on symlinks.
+func Xattrs(path string) (map[string][]byte, error) {
+	names, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	xattrs := map[string][]byte{}
+	for _, name := range names {
+		value, err := getXattr(path, name)
+		if err == syscall.ENODATA {
+			// Removed since listing it
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting xattr %s of %s", name, path)
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+func setXattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
+
+func listXattrs(path string) ([]string, error) {
+	for {
+		size, err := syscall.Listxattr(path, nil)
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing xattrs of %s", path)
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, err := syscall.Listxattr(path, buf)
+		if err == syscall.ERANGE {
+			// More were added since getting the size
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing xattrs of %s", path)
+		}
+		var names []string
+		for _, name := range bytes.Split(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := syscall.Getxattr(path, name, buf)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
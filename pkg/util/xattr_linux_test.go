@@ -0,0 +1,114 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func Test_isPreservedXattr(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "user.mime_type", expected: true},
+		{name: "trusted.overlay.opaque", expected: false},
+		{name: "security.capability", expected: true},
+		{name: "security.selinux", expected: false},
+		{name: "system.posix_acl_access", expected: true},
+		{name: "system.posix_acl_access2", expected: false},
+		{name: "user", expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckDeepEqual(t, test.expected, isPreservedXattr(test.name))
+		})
+	}
+}
+
+func TestXattrsInLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xattrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(path, "user.kaniko", []byte("value"), 0); err != nil {
+		if err == syscall.ENOTSUP {
+			t.Skip("xattrs aren't supported by the filesystem")
+		}
+		t.Fatal(err)
+	}
+
+	// The xattr is recorded in the layer
+	var buf bytes.Buffer
+	tw := NewTar(&buf)
+	if err := tw.AddFileToTar(path); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, "value", hdr.PAXRecords["SCHILY.xattr.user.kaniko"])
+
+	// and set when extracting it
+	dest, err := ioutil.TempDir("", "xattrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+	if err := ExtractFile(dest, hdr, tr); err != nil {
+		t.Fatal(err)
+	}
+	xattrs, err := Xattrs(filepath.Join(dest, hdr.Name))
+	testutil.CheckErrorAndDeepEqual(t, false, err, []byte("value"), xattrs["user.kaniko"])
+
+	// and changing only it, which leaves the mtime alone, is detected by the hashers
+	for _, hasher := range []func(string) (string, error){Hasher(), CacheHasher(), MtimeHasher(), RedoHasher()} {
+		before, err := hasher(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := syscall.Setxattr(path, "user.kaniko", []byte("changed"), 0); err != nil {
+			t.Fatal(err)
+		}
+		after, err := hasher(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if before == after {
+			t.Error("expected a different hash when an xattr changes")
+		}
+		if err := syscall.Setxattr(path, "user.kaniko", []byte("value"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}